@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	adaptiveCheckInterval   = 2 * time.Second // how often the controller reads recent latency
+	adaptiveMinSamples      = 10              // fewer recent publishes than this leave the rate alone
+	adaptiveIncrease        = 0.1             // fraction of the configured rate added per healthy check
+	adaptiveDecrease        = 0.5             // factor the rate is multiplied by per saturated check
+	adaptiveRecentLatencies = 4096            // latest latencies the P95 is taken over
+)
+
+// adaptiveRate is an AIMD controller for the fleet's publish rate. While
+// recent P95 publish latency exceeds the threshold it halves the rate;
+// once latency recovers it adds back a tenth of the configured rate per
+// check. Devices divide their interval by Scale, so 0.5 means every device
+// reports half as often.
+type adaptiveRate struct {
+	threshold time.Duration
+	minScale  float64       // slowest allowed rate, 1 / -adaptive-max-slowdown
+	scale     atomic.Uint64 // float64 bits, in [minScale, 1]
+}
+
+// newAdaptiveRate creates a controller starting at the configured rate
+func newAdaptiveRate(threshold time.Duration, maxSlowdown float64) *adaptiveRate {
+	a := &adaptiveRate{threshold: threshold, minScale: 1 / maxSlowdown}
+	a.scale.Store(math.Float64bits(1))
+	return a
+}
+
+// Scale returns the current fraction of the configured publish rate
+func (a *adaptiveRate) Scale() float64 {
+	return math.Float64frombits(a.scale.Load())
+}
+
+// interval stretches a device's configured interval by the current scale
+func (a *adaptiveRate) interval(base time.Duration) time.Duration {
+	return time.Duration(float64(base) / a.Scale())
+}
+
+// adjust applies one AIMD step for the observed P95 and returns the new scale
+func (a *adaptiveRate) adjust(p95 time.Duration) float64 {
+	scale := a.Scale()
+	if p95 > a.threshold {
+		scale = math.Max(scale*adaptiveDecrease, a.minScale)
+	} else {
+		scale = math.Min(scale+adaptiveIncrease, 1)
+	}
+	a.scale.Store(math.Float64bits(scale))
+	return scale
+}
+
+// run checks recent publish latency every adaptiveCheckInterval until ctx
+// is cancelled, logging and recording every rate change
+func (a *adaptiveRate) run(ctx context.Context, metrics *MetricsTracker, interval time.Duration) {
+	ticker := time.NewTicker(adaptiveCheckInterval)
+	defer ticker.Stop()
+
+	metrics.RecordRateScale(a.Scale())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		p95, samples := metrics.TakeRecentP95()
+		if samples < adaptiveMinSamples {
+			continue
+		}
+		before := a.Scale()
+		p95Latency := time.Duration(p95) * time.Millisecond
+		scale := a.adjust(p95Latency)
+		if scale == before {
+			continue
+		}
+
+		metrics.RecordRateScale(scale)
+		icon := "🐢"
+		if scale > before {
+			icon = "🐇"
+		}
+		logEvent(slog.LevelInfo,
+			fmt.Sprintf("%s Recent P95 %v (threshold %v): publish rate %.0f%% -> %.0f%% (interval %v)",
+				icon, p95Latency, a.threshold, before*100, scale*100, a.interval(interval).Round(time.Millisecond)),
+			"adaptive rate", "p95_latency_ms", p95, "threshold_ms", a.threshold.Milliseconds(),
+			"previous_scale", before, "scale", scale)
+	}
+}
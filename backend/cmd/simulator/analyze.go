@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// AnalyzeFilter selects the CSV rows -analyze recomputes stats from
+type AnalyzeFilter struct {
+	DeviceID string    // empty = every device
+	Since    time.Time // zero = from the first row
+	Until    time.Time // zero = to the last row
+}
+
+// parseAnalyzeTime parses a -since/-until bound; empty means unbounded
+func parseAnalyzeTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time %q must be RFC3339, e.g. 2025-01-02T15:04:05Z", value)
+	}
+	return t, nil
+}
+
+// analyzeCSV replays the rows of a metrics CSV that match filter into a
+// fresh tracker and returns its stats, measured over the rows' time span.
+// Columns are located by header, so CSVs from older simulator versions
+// (without tenant, bytes or warmup columns) can still be analyzed.
+func analyzeCSV(path string, filter AnalyzeFilter, opts MetricsOptions) (map[string]interface{}, *MetricsTracker, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open metrics CSV: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read metrics CSV header: %w", err)
+	}
+	col := make(map[string]int, len(header))
+	for i, name := range header {
+		col[name] = i
+	}
+	for _, required := range []string{"timestamp", "device_id", "publish_latency_ms", "success"} {
+		if _, ok := col[required]; !ok {
+			return nil, nil, fmt.Errorf("metrics CSV has no %s column", required)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := col[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	opts.LatencySampleSize = math.MaxInt32 // offline percentiles are exact
+	var m *MetricsTracker
+	var last time.Time
+	warmupSeen := false
+	skipped := 0
+	line := 1
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read metrics CSV line %d: %w", line, err)
+		}
+
+		// Rows that do not parse, e.g. one cut short by a crash, are skipped
+		ts, err := time.Parse(time.RFC3339, field(row, "timestamp"))
+		if err != nil {
+			skipped++
+			continue
+		}
+		deviceID := field(row, "device_id")
+		if (filter.DeviceID != "" && deviceID != filter.DeviceID) ||
+			(!filter.Since.IsZero() && ts.Before(filter.Since)) ||
+			(!filter.Until.IsZero() && ts.After(filter.Until)) {
+			continue
+		}
+		latencyMs, err := strconv.ParseInt(field(row, "publish_latency_ms"), 10, 64)
+		if err != nil {
+			skipped++
+			continue
+		}
+		bytes, _ := strconv.Atoi(field(row, "bytes")) // absent in older CSVs
+		warmup := field(row, "warmup") == "1"
+
+		if m == nil {
+			m = newTracker(ts, opts)
+		}
+		// The steady state starts with the first row published after warmup
+		if warmup {
+			warmupSeen = true
+		} else if warmupSeen && m.warmupEnd.Equal(m.startTime) {
+			m.warmupEnd = ts
+		}
+		m.record(deviceID, latencyMs, bytes, field(row, "success") == "1", warmup)
+		last = ts
+	}
+	if skipped > 0 {
+		log.Printf("⚠️  Skipped %d malformed rows in %s", skipped, path)
+	}
+	if m == nil {
+		return nil, nil, fmt.Errorf("no rows in %s match the filter", path)
+	}
+
+	stats := m.statsAt(last)
+	delete(stats, "qos") // not recorded in the CSV
+	return stats, m, nil
+}
+
+// runAnalyze prints and returns the stats of a metrics CSV
+func runAnalyze(cfg *Config, buckets []int64) (map[string]interface{}, error) {
+	since, _ := parseAnalyzeTime(cfg.Since) // validated with the config
+	until, _ := parseAnalyzeTime(cfg.Until)
+	filter := AnalyzeFilter{DeviceID: cfg.FilterDevice, Since: since, Until: until}
+
+	stats, tracker, err := analyzeCSV(cfg.AnalyzeFile, filter, MetricsOptions{LatencyBuckets: buckets})
+	if err != nil {
+		return nil, err
+	}
+
+	printStats(stats)
+	if cfg.PerDeviceReport {
+		tracker.PrintPerDeviceStats()
+	}
+	return stats, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Anomaly mutates a generated reading to inject one clinical event
+type Anomaly func(m *Metrics, rng *rand.Rand)
+
+// anomalyTypes are the events selectable with -anomaly-types
+var anomalyTypes = map[string]Anomaly{
+	"tachycardia": tachycardia,
+	"bradycardia": bradycardia,
+	"hypoxia":     hypoxia,
+	"fever":       fever,
+	"hypothermia": hypothermia,
+}
+
+// parseAnomalyTypes parses a comma-separated list of anomaly type names.
+// An empty spec returns nil, which keeps the vitals model's built-in anomaly.
+func parseAnomalyTypes(spec string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := anomalyTypes[name]; !ok {
+			return nil, fmt.Errorf("unknown anomaly type %q (want tachycardia, bradycardia, hypoxia, fever, or hypothermia)", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// AnomalyRate is an anomaly type drawn on its own, independently of
+// -anomaly-rate, so e.g. hypoxia alerting can be exercised apart from fever
+type AnomalyRate struct {
+	Name string
+	Rate float64 // chance per reading
+}
+
+// parseAnomalyRates parses comma-separated name:rate pairs, e.g. "hypoxia:0.02"
+func parseAnomalyRates(spec string) ([]AnomalyRate, error) {
+	var rates []AnomalyRate
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, rateStr, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("anomaly rate %q must be name:rate", entry)
+		}
+		if _, known := anomalyTypes[name]; !known {
+			return nil, fmt.Errorf("unknown anomaly type %q (want tachycardia, bradycardia, hypoxia, fever, or hypothermia)", name)
+		}
+		rate, err := strconv.ParseFloat(rateStr, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("anomaly rate %q for %s must be between 0 and 1", rateStr, name)
+		}
+		rates = append(rates, AnomalyRate{Name: name, Rate: rate})
+	}
+	return rates, nil
+}
+
+// drawAnomaly decides whether a reading at now is anomalous, and of which
+// -anomaly-types type; "" is the vitals model's built-in anomaly. With
+// -anomaly-duration an anomaly that triggers stays active on every reading
+// until it runs out, so one event raises a sustained alert rather than a
+// single-message blip.
+func (s *DeviceState) drawAnomaly(cfg DeviceConfig, now time.Time, rng *rand.Rand) (bool, string) {
+	if cfg.AnomalyExcluded {
+		return false, ""
+	}
+	if cfg.AnomalyDuration > 0 && now.Before(s.AnomalyUntil) {
+		return true, s.AnomalyType
+	}
+	if rng.Float32() >= float32(cfg.AnomalyRate) {
+		return false, ""
+	}
+
+	name := ""
+	if len(cfg.AnomalyTypes) > 0 {
+		name = cfg.AnomalyTypes[rng.Intn(len(cfg.AnomalyTypes))]
+	}
+	if cfg.AnomalyDuration > 0 {
+		s.AnomalyType = name
+		s.AnomalyUntil = now.Add(jitteredInterval(cfg.AnomalyDuration, cfg.AnomalyDurationJitter, rng))
+	}
+	return true, name
+}
+
+// tachycardia drives heart rate to 150-179 bpm
+func tachycardia(m *Metrics, rng *rand.Rand) {
+	m.HeartRate = 150 + rng.Intn(30)
+}
+
+// bradycardia drops heart rate to 35-49 bpm
+func bradycardia(m *Metrics, rng *rand.Rand) {
+	m.HeartRate = 35 + rng.Intn(15)
+}
+
+// hypoxia drops SpO2 into the 80s with compensatory tachycardia and faster
+// breathing; temperature is left normal so it never reads as fever
+func hypoxia(m *Metrics, rng *rand.Rand) {
+	m.SpO2 = 80 + rng.Intn(10)
+	m.HeartRate += 20 + rng.Intn(16)
+	m.RespRate += 6 + rng.Intn(7)
+}
+
+// fever raises temperature to 38.0-39.5 C, with heart rate up ~10 bpm per degree
+func fever(m *Metrics, rng *rand.Rand) {
+	rise := 38.0 + rng.Float64()*1.5 - m.TempC
+	m.TempC += rise
+	m.HeartRate += int(10 * rise)
+}
+
+// hypothermia drops temperature to 34.0-35.0 C with a slowed heart rate and breathing
+func hypothermia(m *Metrics, rng *rand.Rand) {
+	m.TempC = 34.0 + rng.Float64()
+	m.HeartRate -= 10 + rng.Intn(11)
+	m.RespRate -= 2 + rng.Intn(3)
+}
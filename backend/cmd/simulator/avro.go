@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hamba/avro/v2"
+)
+
+// avroTopicSuffix marks telemetry topics carrying Avro payloads
+const avroTopicSuffix = "/avro"
+
+// avroAPI maps Avro record fields to Telemetry by its JSON names, so a
+// schema uses the same field names as the JSON payload
+var avroAPI = avro.Config{TagKey: "json"}.Freeze()
+
+// avroTelemetry is Telemetry as written to Avro, which has no unsigned long
+type avroTelemetry struct {
+	Telemetry
+	Seq int64 `json:"seq"`
+}
+
+// loadAvroEncoding parses the .avsc schema at path and returns an encoding
+// that writes bare Avro binary datums, without a container or registry
+// header, as schema-registry pipelines keep the schema out of band
+func loadAvroEncoding(path string) (payloadEncoding, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return payloadEncoding{}, fmt.Errorf("failed to read Avro schema: %w", err)
+	}
+	schema, err := avro.Parse(string(data))
+	if err != nil {
+		return payloadEncoding{}, fmt.Errorf("failed to parse Avro schema: %w", err)
+	}
+
+	enc := payloadEncoding{
+		topicSuffix: avroTopicSuffix,
+		contentType: "avro/binary",
+		marshal: func(t Telemetry) ([]byte, error) {
+			return avroAPI.Marshal(schema, avroTelemetry{Telemetry: t, Seq: int64(t.Seq)})
+		},
+		unmarshal: func(data []byte, t *Telemetry) error {
+			var record avroTelemetry
+			if err := avroAPI.Unmarshal(schema, data, &record); err != nil {
+				return err
+			}
+			*t = record.Telemetry
+			t.Seq = uint64(record.Seq)
+			return nil
+		},
+	}
+
+	// Fail at startup, not on the first publish, if the schema does not fit
+	if _, err := enc.marshal(Telemetry{}); err != nil {
+		return payloadEncoding{}, fmt.Errorf("Avro schema %s does not match telemetry: %w", path, err)
+	}
+	return enc, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// RegressionTolerance is how much worse than the baseline a run may be,
+// as fractions of the baseline value
+type RegressionTolerance struct {
+	ThroughputDrop float64 // e.g. 0.1 allows 10% lower messages_per_sec
+	P95Increase    float64 // e.g. 0.2 allows 20% higher p95_latency_ms
+}
+
+// baselineMetric is a stat compared against the baseline; gated metrics
+// fail the comparison when they regress beyond tolerance
+type baselineMetric struct {
+	key            string
+	label          string
+	higherIsBetter bool
+	tolerance      func(RegressionTolerance) float64 // nil = informational only
+}
+
+// baselineMetrics are the stats shown in the comparison, in display order
+var baselineMetrics = []baselineMetric{
+	{key: "messages_per_sec", label: "Throughput (msg/s)", higherIsBetter: true,
+		tolerance: func(t RegressionTolerance) float64 { return t.ThroughputDrop }},
+	{key: "p50_latency_ms", label: "P50 Latency (ms)"},
+	{key: "p95_latency_ms", label: "P95 Latency (ms)",
+		tolerance: func(t RegressionTolerance) float64 { return t.P95Increase }},
+	{key: "p99_latency_ms", label: "P99 Latency (ms)"},
+	{key: "total_errors", label: "Errors"},
+}
+
+// loadBaseline reads the stats of a stored run: a -metrics-json report, or
+// a bare stats object
+func loadBaseline(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read baseline: %w", err)
+	}
+
+	var report struct {
+		Stats map[string]interface{} `json:"stats"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse baseline: %w", err)
+	}
+	if report.Stats != nil {
+		return report.Stats, nil
+	}
+
+	var stats map[string]interface{}
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil, fmt.Errorf("failed to parse baseline: %w", err)
+	}
+	if _, ok := stats["messages_per_sec"]; !ok {
+		return nil, fmt.Errorf("baseline %s has no stats", path)
+	}
+	return stats, nil
+}
+
+// statFloat converts a stats value, native or decoded from JSON, to float64
+func statFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// compareBaseline prints the run's stats next to the baseline's and
+// returns a description of every gated metric that regressed beyond tol
+func compareBaseline(stats, baseline map[string]interface{}, tol RegressionTolerance) []string {
+	separator := strings.Repeat("=", 60)
+
+	if !jsonLogs {
+		fmt.Fprintln(reportOut, "\n"+separator)
+		fmt.Fprintln(reportOut, "BASELINE COMPARISON")
+		fmt.Fprintln(reportOut, separator)
+		fmt.Fprintf(reportOut, "%-20s %12s %12s %10s\n", "Metric", "Baseline", "Current", "Change")
+	}
+
+	var regressions []string
+	for _, metric := range baselineMetrics {
+		current, ok1 := statFloat(stats[metric.key])
+		base, ok2 := statFloat(baseline[metric.key])
+		if !ok1 || !ok2 {
+			continue
+		}
+
+		change := "n/a"
+		var delta float64
+		if base != 0 {
+			delta = (current - base) / base
+			change = fmt.Sprintf("%+.1f%%", delta*100)
+		}
+		if jsonLogs {
+			slog.Info("baseline comparison", "metric", metric.key, "baseline", base, "current", current, "change_pct", delta*100)
+		} else {
+			fmt.Fprintf(reportOut, "%-20s %12.2f %12.2f %10s\n", metric.label, base, current, change)
+		}
+
+		// A zero baseline, e.g. sub-millisecond P95, has no relative change to gate on
+		if metric.tolerance == nil || base == 0 {
+			continue
+		}
+		limit := metric.tolerance(tol)
+		worse := delta
+		if metric.higherIsBetter {
+			worse = -delta
+		}
+		if worse > limit {
+			regressions = append(regressions, fmt.Sprintf("%s %.2f vs baseline %.2f (%s, tolerance %.0f%%)",
+				metric.label, current, base, change, limit*100))
+		}
+	}
+	if !jsonLogs {
+		fmt.Fprintln(reportOut, separator)
+	}
+
+	for _, r := range regressions {
+		logEvent(slog.LevelWarn, "📉 Regression: "+r, "regression", "detail", r)
+	}
+	return regressions
+}
+
+// checkBaseline compares stats against the -baseline run and returns an
+// error if it regressed and -fail-on-regression is set
+func checkBaseline(cfg *Config, stats, baseline map[string]interface{}) error {
+	tol := RegressionTolerance{ThroughputDrop: cfg.MaxThroughputDrop, P95Increase: cfg.MaxP95Increase}
+	regressions := compareBaseline(stats, baseline, tol)
+	if len(regressions) == 0 {
+		logEvent(slog.LevelInfo, fmt.Sprintf("✅ No regression against baseline %s", cfg.Baseline),
+			"no regression", "baseline", cfg.Baseline)
+		return nil
+	}
+	if cfg.FailOnRegression {
+		return fmt.Errorf("%d metrics regressed against baseline %s", len(regressions), cfg.Baseline)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	caloriesWeightKg    = 70.0  // body weight assumed for every device
+	caloriesMaxMET      = 8.0   // MET at the step maximum or maximal heart rate, about a jog
+	caloriesMaxHR       = 190.0 // heart rate taken as maximal effort
+	caloriesStepsWeight = 0.7   // share of the intensity inferred from steps; the rest from heart rate
+)
+
+// caloriesBurned estimates the energy burned over d with the MET formula
+// (kcal = MET x kg x hours). Intensity blends the activity inferred from
+// steps with how far heart rate has climbed from the resting baseline
+// towards maximal, so a resting device burns 1 MET.
+func caloriesBurned(hr, baseHR int, activity float64, d time.Duration) float64 {
+	hrIntensity := 0.0
+	if hr > baseHR {
+		hrIntensity = math.Min(float64(hr-baseHR)/math.Max(caloriesMaxHR-float64(baseHR), 1), 1)
+	}
+	intensity := caloriesStepsWeight*activity + (1-caloriesStepsWeight)*hrIntensity
+	met := 1 + (caloriesMaxMET-1)*intensity
+	return met * caloriesWeightKg * d.Hours()
+}
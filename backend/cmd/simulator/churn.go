@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// Churner is implemented by publishers that hold a connection the device
+// can drop and later re-establish
+type Churner interface {
+	Disconnect() error
+	Reconnect() error
+}
+
+// churn takes a device offline for a random backoff of backoff/2..backoff,
+// then brings it back, retrying failed reconnects after another backoff.
+// Publishers without a connection simply stop publishing for the pause.
+// It returns false if ctx was cancelled before the device came back.
+func churn(ctx context.Context, publisher Publisher, metrics *MetricsTracker, deviceID string, backoff time.Duration, rng *rand.Rand) bool {
+	churner, connected := findPublisher[Churner](publisher)
+
+	metrics.RecordChurnDisconnect()
+	if connected {
+		if err := churner.Disconnect(); err != nil {
+			log.Printf("⚠️  [%s] Churn disconnect error: %v", deviceID, err)
+		}
+	}
+
+	for {
+		pause := backoff/2 + time.Duration(rng.Int63n(int64(backoff/2)+1))
+		log.Printf("🔌 [%s] Churned offline for %v", deviceID, pause.Round(time.Millisecond))
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(pause):
+		}
+
+		if !connected {
+			return true
+		}
+		err := churner.Reconnect()
+		if err == nil {
+			log.Printf("🔌 [%s] Reconnected after churn", deviceID)
+			return true
+		}
+		metrics.RecordChurnReconnectError()
+		log.Printf("❌ [%s] Churn reconnect error: %v", deviceID, err)
+	}
+}
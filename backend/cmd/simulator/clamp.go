@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MetricBounds are the physiologically plausible limits every reading is
+// clamped to (-bounds), after anomalies and before scenario overrides, so
+// random walks and stacked anomalies never produce values a downstream
+// validator would reject
+type MetricBounds struct {
+	HeartRate IntRange
+	SpO2      IntRange
+	TempC     FloatRange
+}
+
+// defaultBounds are used for any metric -bounds does not name
+var defaultBounds = MetricBounds{
+	HeartRate: IntRange{Min: 25, Max: 230},
+	SpO2:      IntRange{Min: 0, Max: 100},
+	TempC:     FloatRange{Min: 30.0, Max: 43.0},
+}
+
+// parseMetricBounds parses -bounds, comma-separated metric=min:max pairs
+// for hr, spo2 and temp, e.g. "hr=30:200,temp=34:42". Unnamed metrics keep
+// their default bounds; "off" disables clamping and returns nil.
+func parseMetricBounds(spec string) (*MetricBounds, error) {
+	if strings.TrimSpace(spec) == "off" {
+		return nil, nil
+	}
+
+	bounds := defaultBounds
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, limits, ok := strings.Cut(entry, "=")
+		minStr, maxStr, ok2 := strings.Cut(limits, ":")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("bounds %q must be metric=min:max", entry)
+		}
+		lo, err1 := strconv.ParseFloat(minStr, 64)
+		hi, err2 := strconv.ParseFloat(maxStr, 64)
+		if err1 != nil || err2 != nil || lo > hi {
+			return nil, fmt.Errorf("bounds %q must have numeric min <= max", entry)
+		}
+
+		switch name {
+		case "hr":
+			bounds.HeartRate = IntRange{Min: int(lo), Max: int(hi)}
+		case "spo2":
+			if lo < 0 || hi > 100 {
+				return nil, fmt.Errorf("spo2 bounds %q must be within 0-100", limits)
+			}
+			bounds.SpO2 = IntRange{Min: int(lo), Max: int(hi)}
+		case "temp":
+			bounds.TempC = FloatRange{Min: lo, Max: hi}
+		default:
+			return nil, fmt.Errorf("unknown bounds metric %q (want hr, spo2, or temp)", name)
+		}
+	}
+	return &bounds, nil
+}
+
+// clamp limits m to the bounds
+func (b *MetricBounds) clamp(m *Metrics) {
+	m.HeartRate = min(max(m.HeartRate, b.HeartRate.Min), b.HeartRate.Max)
+	m.SpO2 = min(max(m.SpO2, b.SpO2.Min), b.SpO2.Max)
+	m.TempC = math.Min(math.Max(m.TempC, b.TempC.Min), b.TempC.Max)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// deviceClock is a device's own clock for the ts it reports (-clock-drift):
+// it runs fast or slow against true time by a fixed rate drawn per device,
+// so its offset grows steadily over the run
+type deviceClock struct {
+	start time.Time
+	rate  float64 // seconds gained per second; negative runs slow
+}
+
+// newDeviceClock draws a drift rate within ±maxPerHour per hour of true time
+func newDeviceClock(maxPerHour time.Duration, rng *rand.Rand) deviceClock {
+	maxRate := maxPerHour.Seconds() / time.Hour.Seconds()
+	return deviceClock{start: time.Now(), rate: maxRate * (rng.Float64()*2 - 1)}
+}
+
+// Now returns the device's reading of the true time now
+func (c deviceClock) Now() time.Time {
+	now := time.Now()
+	return now.Add(time.Duration(float64(now.Sub(c.start)) * c.rate))
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Config holds every simulator setting. Values start from the flag
+// defaults, are replaced by the optional -config YAML file, and flags
+// passed explicitly on the command line win over both.
+type Config struct {
+	Broker                string                    `yaml:"broker"`
+	Transport             string                    `yaml:"transport"`
+	HTTPEndpoint          string                    `yaml:"http_endpoint"`
+	Devices               int                       `yaml:"devices"`
+	Interval              time.Duration             `yaml:"interval"`
+	Tenant                string                    `yaml:"tenant"`
+	Tenants               string                    `yaml:"tenants"`
+	Duration              time.Duration             `yaml:"duration"`
+	MaxMessages           int64                     `yaml:"max_messages"`
+	MetricsFile           string                    `yaml:"metrics"`
+	NoCSV                 bool                      `yaml:"no_csv"`
+	CSVFlushInterval      time.Duration             `yaml:"csv_flush_interval"`
+	QoS                   int                       `yaml:"qos"`
+	Retained              bool                      `yaml:"retained"`
+	PublishMetadata       bool                      `yaml:"publish_metadata"`
+	CleanSession          bool                      `yaml:"clean_session"`
+	MQTTVersion           string                    `yaml:"mqtt_version"`
+	OnDisconnect          string                    `yaml:"on_disconnect"`
+	DisconnectBuffer      int                       `yaml:"disconnect_buffer"`
+	MessageExpiry         time.Duration             `yaml:"message_expiry"`
+	ClientIDPrefix        string                    `yaml:"client_id_prefix"`
+	KeepAlive             time.Duration             `yaml:"keepalive"`
+	PingTimeout           time.Duration             `yaml:"ping_timeout"`
+	ConnectTimeout        time.Duration             `yaml:"connect_timeout"`
+	ConnectRetries        int                       `yaml:"connect_retries"`
+	Compress              bool                      `yaml:"compress"`
+	PayloadPaddingBytes   int                       `yaml:"payload_padding_bytes"`
+	Encoding              string                    `yaml:"encoding"`
+	AvroSchema            string                    `yaml:"avro_schema"`
+	TimestampFormat       string                    `yaml:"ts_format"`
+	TopicTemplate         string                    `yaml:"topic_template"`
+	PublishTimeout        time.Duration             `yaml:"publish_timeout"`
+	ShutdownTimeout       time.Duration             `yaml:"shutdown_timeout"`
+	MaxInflight           int                       `yaml:"max_inflight"`
+	Jitter                float64                   `yaml:"jitter"`
+	Burst                 int                       `yaml:"burst"`
+	InjectErrorRate       float64                   `yaml:"inject_error_rate"`
+	BurstMode             string                    `yaml:"burst_mode"`
+	AdaptiveRate          bool                      `yaml:"adaptive_rate"`
+	AdaptiveP95           time.Duration             `yaml:"adaptive_p95"`
+	AdaptiveMaxSlowdown   float64                   `yaml:"adaptive_max_slowdown"`
+	CACert                string                    `yaml:"ca_cert"`
+	ClientCert            string                    `yaml:"client_cert"`
+	ClientKey             string                    `yaml:"client_key"`
+	InsecureSkipVerify    bool                      `yaml:"insecure_skip_verify"`
+	Username              string                    `yaml:"username"`
+	Password              string                    `yaml:"password"`
+	Seed                  int64                     `yaml:"seed"`
+	MetricsJSON           string                    `yaml:"metrics_json"`
+	Manifest              string                    `yaml:"manifest"`
+	StateFile             string                    `yaml:"state_file"`
+	Baseline              string                    `yaml:"baseline"`
+	FailOnRegression      bool                      `yaml:"fail_on_regression"`
+	MaxThroughputDrop     float64                   `yaml:"max_throughput_drop"`
+	MaxP95Increase        float64                   `yaml:"max_p95_increase"`
+	SLOP95Ms              int64                     `yaml:"slo_p95_ms"`
+	SLOErrorRate          float64                   `yaml:"slo_error_rate"`
+	LatencySampleSize     int                       `yaml:"latency_sample_size"`
+	MetricsShards         int                       `yaml:"metrics_shards"`
+	LatencyBuckets        string                    `yaml:"latency_buckets"`
+	PerDeviceReport       bool                      `yaml:"per_device_report"`
+	RampUp                time.Duration             `yaml:"rampup"`
+	Warmup                time.Duration             `yaml:"warmup"`
+	BatteryDrainPerHour   float64                   `yaml:"battery_drain_per_hour"`
+	BatteryRecharge       bool                      `yaml:"battery_recharge"`
+	StepsPerIntervalMax   int                       `yaml:"steps_per_interval_max"`
+	VitalsModel           string                    `yaml:"vitals_model"`
+	Generators            string                    `yaml:"generators"`
+	FWVersions            string                    `yaml:"fw_versions"`
+	FWRolloutDuration     time.Duration             `yaml:"fw_rollout_duration"`
+	FWRolloutPercent      float64                   `yaml:"fw_rollout_percent"`
+	ChurnRate             float64                   `yaml:"churn_rate"`
+	ChurnBackoff          time.Duration             `yaml:"churn_backoff"`
+	HomeLat               float64                   `yaml:"home_lat"`
+	HomeLon               float64                   `yaml:"home_lon"`
+	GPSRadiusM            float64                   `yaml:"gps_radius_m"`
+	Glucose               bool                      `yaml:"glucose"`
+	MealTimes             string                    `yaml:"meal_times"`
+	Bounds                string                    `yaml:"bounds"`
+	FallProbability       float64                   `yaml:"fall_probability"`
+	AnomalyRate           float64                   `yaml:"anomaly_rate"`
+	AnomalyDuration       time.Duration             `yaml:"anomaly_duration"`
+	AnomalyDevices        string                    `yaml:"anomaly_devices"`
+	AnomalyDurationJitter float64                   `yaml:"anomaly_duration_jitter"`
+	AnomalyTypes          string                    `yaml:"anomaly_types"`
+	AnomalyTypeRates      string                    `yaml:"anomaly_type_rates"`
+	Circadian             bool                      `yaml:"circadian"`
+	PrometheusAddr        string                    `yaml:"prometheus_addr"`
+	HealthAddr            string                    `yaml:"health_addr"`
+	ScenarioFile          string                    `yaml:"scenario"`
+	RecordFile            string                    `yaml:"record"`
+	ReplayFile            string                    `yaml:"replay"`
+	AnalyzeFile           string                    `yaml:"analyze"`
+	FilterDevice          string                    `yaml:"filter_device"`
+	Since                 string                    `yaml:"since"`
+	Until                 string                    `yaml:"until"`
+	ReplaySpeed           float64                   `yaml:"replay_speed"`
+	Verify                bool                      `yaml:"verify"`
+	DryRun                bool                      `yaml:"dry_run"`
+	StdoutNDJSON          bool                      `yaml:"stdout_ndjson"`
+	LogFormat             string                    `yaml:"log_format"`
+	ErrorLogInterval      time.Duration             `yaml:"error_log_interval"`
+	ClockSkew             time.Duration             `yaml:"clock_skew"`
+	ClockDrift            time.Duration             `yaml:"clock_drift"`
+	VerifyProcessingDelay time.Duration             `yaml:"verify_processing_delay"`
+	DeviceOverrides       map[string]DeviceOverride `yaml:"device_overrides"`
+	DeviceProfiles        map[string]Profile        `yaml:"device_profiles"`
+	Profiles              string                    `yaml:"profiles"`
+}
+
+// DeviceOverride pins baseline vitals for a single device (zero = keep the random baseline)
+type DeviceOverride struct {
+	BaseHR    int       `yaml:"base_hr"`
+	BaseTempC float64   `yaml:"base_temp_c"`
+	BaseSpO2  int       `yaml:"base_spo2"`
+	Schedule  *Schedule `yaml:"schedule"` // replaces the profile's schedule
+}
+
+// loadConfigFile reads the YAML file at path into cfg, then re-applies any
+// flags that were set explicitly so they take precedence over the file
+func loadConfigFile(path string, cfg *Config, fs *flag.FlagSet) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	explicit := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	if err := yaml.UnmarshalWithOptions(data, cfg, yaml.DisallowUnknownField()); err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	for name, value := range explicit {
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("failed to re-apply -%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// SLO returns the run's -slo-p95-ms and -slo-error-rate targets
+func (c *Config) SLO() SLO {
+	return SLO{P95Ms: c.SLOP95Ms, ErrorRate: c.SLOErrorRate}
+}
+
+// Validate checks the configuration and reports every problem at once
+func (c *Config) Validate() error {
+	var errs []error
+
+	if len(c.Brokers()) == 0 {
+		errs = append(errs, fmt.Errorf("broker must not be empty"))
+	} else if c.Transport == "mqtt" {
+		for _, broker := range c.Brokers() {
+			if _, _, err := brokerScheme(broker); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	switch c.Transport {
+	case "mqtt":
+	case "http":
+		if c.HTTPEndpoint == "" {
+			errs = append(errs, fmt.Errorf("http endpoint must not be empty for http transport"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("transport %q must be mqtt or http", c.Transport))
+	}
+	if !mqttVersions[c.MQTTVersion] {
+		errs = append(errs, fmt.Errorf("mqtt version %q must be 3.1.1 or 5", c.MQTTVersion))
+	}
+	if c.MQTTVersion == "5" {
+		if len(c.Brokers()) > 1 {
+			errs = append(errs, fmt.Errorf("mqtt version 5 supports a single broker"))
+		}
+		if c.ReplayFile != "" {
+			errs = append(errs, fmt.Errorf("mqtt version 5 cannot be used with replay"))
+		}
+		if c.PingTimeout != defaultPingTimeout {
+			errs = append(errs, fmt.Errorf("ping timeout needs mqtt version 3.1.1; the MQTT 5 client has no ping timeout"))
+		}
+		if c.KeepAlive > maxKeepAlive5 {
+			errs = append(errs, fmt.Errorf("keepalive %v must be at most %v with mqtt version 5", c.KeepAlive, maxKeepAlive5))
+		}
+	}
+	if !disconnectPolicies[c.OnDisconnect] {
+		errs = append(errs, fmt.Errorf("on-disconnect %q must be error, drop or buffer", c.OnDisconnect))
+	}
+	if c.DisconnectBuffer < 1 {
+		errs = append(errs, fmt.Errorf("disconnect buffer %d must be >= 1", c.DisconnectBuffer))
+	}
+	if c.MessageExpiry < 0 {
+		errs = append(errs, fmt.Errorf("message expiry %v must be >= 0", c.MessageExpiry))
+	} else if c.MessageExpiry > 0 && c.MessageExpiry < time.Second {
+		errs = append(errs, fmt.Errorf("message expiry %v must be at least 1s", c.MessageExpiry))
+	} else if c.MessageExpiry > 0 && c.MQTTVersion != "5" {
+		errs = append(errs, fmt.Errorf("message expiry needs mqtt version 5"))
+	}
+	if c.KeepAlive < time.Second {
+		errs = append(errs, fmt.Errorf("keepalive %v must be at least 1s", c.KeepAlive))
+	}
+	if c.PingTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("ping timeout %v must be > 0", c.PingTimeout))
+	}
+	if c.ConnectTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("connect timeout %v must be > 0", c.ConnectTimeout))
+	}
+	if c.ConnectRetries < 0 {
+		errs = append(errs, fmt.Errorf("connect retries %d must be >= 0", c.ConnectRetries))
+	}
+	if c.ClientIDPrefix == "" {
+		errs = append(errs, fmt.Errorf("client id prefix must not be empty"))
+	}
+	if c.Devices < 0 {
+		errs = append(errs, fmt.Errorf("devices %d must be >= 0", c.Devices))
+	}
+	if c.Interval <= 0 {
+		errs = append(errs, fmt.Errorf("interval %v must be > 0", c.Interval))
+	}
+	if c.Tenant == "" {
+		errs = append(errs, fmt.Errorf("tenant must not be empty"))
+	}
+	if n, err := strconv.Atoi(c.Tenants); err == nil && n <= 0 {
+		errs = append(errs, fmt.Errorf("tenants count %d must be > 0", n))
+	}
+	if c.Duration < 0 {
+		errs = append(errs, fmt.Errorf("duration %v must be >= 0", c.Duration))
+	}
+	if c.MaxMessages < 0 {
+		errs = append(errs, fmt.Errorf("max messages %d must be >= 0", c.MaxMessages))
+	}
+	if c.QoS < 0 || c.QoS > 2 {
+		errs = append(errs, fmt.Errorf("qos %d must be 0, 1, or 2", c.QoS))
+	}
+	if c.PublishTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("publish timeout %v must be > 0", c.PublishTimeout))
+	}
+	if c.Jitter < 0 || c.Jitter >= 1 {
+		errs = append(errs, fmt.Errorf("jitter %.2f must be in [0, 1)", c.Jitter))
+	}
+	if c.InjectErrorRate < 0 || c.InjectErrorRate > 1 {
+		errs = append(errs, fmt.Errorf("inject error rate %.4f must be between 0 and 1", c.InjectErrorRate))
+	}
+	if c.Burst < 1 {
+		errs = append(errs, fmt.Errorf("burst %d must be >= 1", c.Burst))
+	}
+	switch c.BurstMode {
+	case "separate":
+	case "array":
+		if c.Encoding != "json" {
+			errs = append(errs, fmt.Errorf("burst mode array needs -encoding json"))
+		}
+		if c.Verify {
+			errs = append(errs, fmt.Errorf("burst mode array cannot be combined with -verify, which matches single readings"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("burst mode %q must be separate or array", c.BurstMode))
+	}
+	if c.AdaptiveRate {
+		if c.AdaptiveP95 <= 0 {
+			errs = append(errs, fmt.Errorf("adaptive p95 threshold %v must be > 0", c.AdaptiveP95))
+		}
+		if c.AdaptiveMaxSlowdown < 1 {
+			errs = append(errs, fmt.Errorf("adaptive max slowdown %.2f must be >= 1", c.AdaptiveMaxSlowdown))
+		}
+	}
+	if c.FailOnRegression && c.Baseline == "" {
+		errs = append(errs, fmt.Errorf("fail-on-regression requires a baseline"))
+	}
+	if c.SLOP95Ms < 0 {
+		errs = append(errs, fmt.Errorf("slo p95 %d ms must be >= 0", c.SLOP95Ms))
+	}
+	if c.SLOErrorRate > 1 {
+		errs = append(errs, fmt.Errorf("slo error rate %.2f must be <= 1", c.SLOErrorRate))
+	}
+	if c.MaxThroughputDrop < 0 || c.MaxP95Increase < 0 {
+		errs = append(errs, fmt.Errorf("regression tolerances must be >= 0"))
+	}
+	if c.PayloadPaddingBytes < 0 {
+		errs = append(errs, fmt.Errorf("payload padding %d bytes must be >= 0", c.PayloadPaddingBytes))
+	}
+	if c.MaxInflight < 0 {
+		errs = append(errs, fmt.Errorf("max in-flight %d must be >= 0", c.MaxInflight))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("shutdown timeout %v must be > 0", c.ShutdownTimeout))
+	}
+	if c.LatencySampleSize <= 0 {
+		errs = append(errs, fmt.Errorf("latency sample size %d must be > 0", c.LatencySampleSize))
+	}
+	if c.CSVFlushInterval < 0 {
+		errs = append(errs, fmt.Errorf("csv flush interval %v must be >= 0", c.CSVFlushInterval))
+	}
+	if c.MetricsShards <= 0 {
+		errs = append(errs, fmt.Errorf("metrics shards %d must be > 0", c.MetricsShards))
+	}
+	if _, err := parseLatencyBuckets(c.LatencyBuckets); err != nil {
+		errs = append(errs, err)
+	}
+	if c.RampUp < 0 {
+		errs = append(errs, fmt.Errorf("rampup %v must be >= 0", c.RampUp))
+	}
+	// TLS files are checked whatever the broker scheme, so a broken setup
+	// fails at startup rather than when the broker is switched to TLS
+	if (c.ClientCert == "") != (c.ClientKey == "") {
+		errs = append(errs, fmt.Errorf("client cert and client key must be provided together"))
+	}
+	for _, file := range []struct{ flag, path string }{
+		{"ca cert", c.CACert}, {"client cert", c.ClientCert}, {"client key", c.ClientKey},
+	} {
+		if file.path == "" {
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", file.flag, file.path, err))
+		}
+	}
+	if c.RecordFile != "" && c.RecordFile == c.ReplayFile {
+		errs = append(errs, fmt.Errorf("record and replay must not use the same file"))
+	}
+	if c.DryRun && c.StdoutNDJSON {
+		errs = append(errs, fmt.Errorf("dry-run and stdout-ndjson both write to stdout; use one"))
+	}
+	if c.DryRun && c.Verify {
+		errs = append(errs, fmt.Errorf("verify needs a broker and cannot be used with dry run"))
+	}
+	if c.ClockDrift < 0 || c.ClockDrift >= time.Hour {
+		errs = append(errs, fmt.Errorf("clock drift %v must be >= 0 and under 1h per hour", c.ClockDrift))
+	}
+	if c.VerifyProcessingDelay < 0 {
+		errs = append(errs, fmt.Errorf("verify processing delay %v must be >= 0", c.VerifyProcessingDelay))
+	} else if c.VerifyProcessingDelay > 0 && !c.Verify {
+		errs = append(errs, fmt.Errorf("verify processing delay needs -verify"))
+	}
+	since, err := parseAnalyzeTime(c.Since)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("since: %w", err))
+	}
+	until, err := parseAnalyzeTime(c.Until)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("until: %w", err))
+	}
+	if !since.IsZero() && !until.IsZero() && until.Before(since) {
+		errs = append(errs, fmt.Errorf("until %s must not be before since %s", c.Until, c.Since))
+	}
+	if c.ReplaySpeed <= 0 {
+		errs = append(errs, fmt.Errorf("replay speed %.2f must be > 0", c.ReplaySpeed))
+	}
+	if c.Warmup < 0 {
+		errs = append(errs, fmt.Errorf("warmup %v must be >= 0", c.Warmup))
+	}
+	if c.BatteryDrainPerHour < 0 {
+		errs = append(errs, fmt.Errorf("battery drain %.2f must be >= 0", c.BatteryDrainPerHour))
+	}
+	if c.StepsPerIntervalMax < 0 {
+		errs = append(errs, fmt.Errorf("steps per interval %d must be >= 0", c.StepsPerIntervalMax))
+	}
+	if _, ok := vitalsModels[c.VitalsModel]; !ok {
+		errs = append(errs, fmt.Errorf("vitals model %q must be independent or correlated", c.VitalsModel))
+	}
+	if _, err := parseTopicTemplate(c.TopicTemplate); err != nil {
+		errs = append(errs, err)
+	}
+	if c.ErrorLogInterval < 0 {
+		errs = append(errs, fmt.Errorf("error log interval %v must be >= 0", c.ErrorLogInterval))
+	}
+	if !logFormats[c.LogFormat] {
+		errs = append(errs, fmt.Errorf("log format %q must be text or json", c.LogFormat))
+	}
+	if c.Encoding == "avro" {
+		if c.AvroSchema == "" {
+			errs = append(errs, fmt.Errorf("encoding avro needs -avro-schema"))
+		}
+	} else if _, ok := encodings[c.Encoding]; !ok {
+		errs = append(errs, fmt.Errorf("encoding %q must be json, protobuf or avro", c.Encoding))
+	}
+	if _, ok := timestampFormats[c.TimestampFormat]; !ok {
+		errs = append(errs, fmt.Errorf("ts format %q must be rfc3339, rfc3339nano or unix-millis", c.TimestampFormat))
+	}
+	if _, err := parseFirmwareVersions(c.FWVersions); err != nil {
+		errs = append(errs, fmt.Errorf("fw versions: %w", err))
+	}
+	if c.FWRolloutDuration < 0 {
+		errs = append(errs, fmt.Errorf("fw rollout duration %v must be >= 0", c.FWRolloutDuration))
+	}
+	if c.FWRolloutPercent < 0 || c.FWRolloutPercent > 100 {
+		errs = append(errs, fmt.Errorf("fw rollout percent %.1f must be between 0 and 100", c.FWRolloutPercent))
+	}
+	if c.ChurnRate < 0 {
+		errs = append(errs, fmt.Errorf("churn rate %.2f must be >= 0", c.ChurnRate))
+	}
+	if c.ChurnBackoff <= 0 {
+		errs = append(errs, fmt.Errorf("churn backoff %v must be > 0", c.ChurnBackoff))
+	}
+	if c.HomeLat < -90 || c.HomeLat > 90 {
+		errs = append(errs, fmt.Errorf("home latitude %.4f must be between -90 and 90", c.HomeLat))
+	}
+	if c.HomeLon < -180 || c.HomeLon > 180 {
+		errs = append(errs, fmt.Errorf("home longitude %.4f must be between -180 and 180", c.HomeLon))
+	}
+	if c.GPSRadiusM <= 0 {
+		errs = append(errs, fmt.Errorf("gps radius %.1f must be > 0", c.GPSRadiusM))
+	}
+	enabled, err := parseGenerators(c.Generators)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if c.Glucose || enabled["glucose"] {
+		if _, err := parseMealTimes(c.MealTimes); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := parseMetricBounds(c.Bounds); err != nil {
+		errs = append(errs, err)
+	}
+	if c.AnomalyRate < 0 || c.AnomalyRate > 1 {
+		errs = append(errs, fmt.Errorf("anomaly rate %.4f must be between 0 and 1", c.AnomalyRate))
+	}
+	if _, err := parseDeviceSelector(c.AnomalyDevices); err != nil {
+		errs = append(errs, fmt.Errorf("anomaly devices: %w", err))
+	}
+	if c.AnomalyDuration < 0 {
+		errs = append(errs, fmt.Errorf("anomaly duration %v must be >= 0", c.AnomalyDuration))
+	}
+	if c.AnomalyDurationJitter < 0 || c.AnomalyDurationJitter >= 1 {
+		errs = append(errs, fmt.Errorf("anomaly duration jitter %.2f must be in [0, 1)", c.AnomalyDurationJitter))
+	}
+	if _, err := parseAnomalyTypes(c.AnomalyTypes); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := parseAnomalyRates(c.AnomalyTypeRates); err != nil {
+		errs = append(errs, err)
+	}
+	if c.FallProbability < 0 || c.FallProbability > 1 {
+		errs = append(errs, fmt.Errorf("fall probability %.4f must be between 0 and 1", c.FallProbability))
+	}
+	for id, o := range c.DeviceOverrides {
+		if o.BaseHR < 0 || o.BaseSpO2 < 0 || o.BaseSpO2 > 100 || o.BaseTempC < 0 {
+			errs = append(errs, fmt.Errorf("device override %s has out-of-range baseline", id))
+		}
+		if o.Schedule != nil {
+			if err := o.Schedule.compile(); err != nil {
+				errs = append(errs, fmt.Errorf("device override %s: %w", id, err))
+			}
+		}
+	}
+	for name, p := range c.DeviceProfiles {
+		if err := p.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("profile %s: %w", name, err))
+		}
+	}
+	profiles := mergedProfiles(c.DeviceProfiles)
+	if _, err := profileAssignments(profiles); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := parseProfileMix(c.Profiles, profiles); err != nil {
+		errs = append(errs, fmt.Errorf("profiles: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// TenantIDs resolves the tenant list: -tenants may be a comma-separated list
+// of IDs or a count of IDs generated from -tenant; empty falls back to -tenant
+func (c *Config) TenantIDs() []string {
+	if c.Tenants == "" {
+		return []string{c.Tenant}
+	}
+
+	if n, err := strconv.Atoi(c.Tenants); err == nil {
+		ids := make([]string, n)
+		for i := range ids {
+			ids[i] = fmt.Sprintf("%s-%02d", c.Tenant, i)
+		}
+		return ids
+	}
+
+	var ids []string
+	for _, id := range strings.Split(c.Tenants, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return []string{c.Tenant}
+	}
+	return ids
+}
+
+// Brokers returns the broker URLs telemetry is published to. More than one
+// fans every message out to all of them.
+func (c *Config) Brokers() []string {
+	var brokers []string
+	for _, broker := range strings.Split(c.Broker, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			brokers = append(brokers, broker)
+		}
+	}
+	return brokers
+}
+
+// brokerFlag is the -broker flag. It may be repeated or given a
+// comma-separated list; the first use replaces the default, and each
+// distinct URL is added once, so re-applying the flag after a config
+// file is loaded does not duplicate brokers.
+type brokerFlag struct {
+	target *string
+	urls   []string
+}
+
+func (f *brokerFlag) String() string {
+	if f.target == nil {
+		return ""
+	}
+	return *f.target
+}
+
+func (f *brokerFlag) Set(value string) error {
+	for _, url := range strings.Split(value, ",") {
+		if url = strings.TrimSpace(url); url != "" && !slices.Contains(f.urls, url) {
+			f.urls = append(f.urls, url)
+		}
+	}
+	*f.target = strings.Join(f.urls, ",")
+	return nil
+}
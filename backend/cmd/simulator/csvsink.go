@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	csvBufferSize   = 4096             // records queued before RecordPublish blocks
+	csvFlushEvery   = 1000             // flush after this many records
+	csvMaxErrors    = 10               // consecutive write errors before the CSV is abandoned
+	csvWarnInterval = 10 * time.Second // minimum gap between write error warnings
+)
+
+// csvRecord is one row of the per-publish CSV
+type csvRecord struct {
+	timestamp time.Time
+	tenantID  string
+	deviceID  string
+	latencyMs int64
+	bytes     int
+	success   bool
+	warmup    bool // published during -warmup
+	anomaly   bool // carried an injected anomaly
+}
+
+// csvSink funnels records through a buffered channel to a single writer
+// goroutine, so publishers never contend on the file and rows reach disk
+// periodically instead of only at shutdown
+type csvSink struct {
+	records       chan csvRecord
+	done          chan struct{}
+	flushInterval time.Duration // flush at least this often while records are pending; 0 = by count only
+	writer        *csv.Writer
+	file          *os.File
+	errors        atomic.Int64 // failed writes and flushes, e.g. disk full
+	consecutive   int          // errors since the last successful flush
+	lastWarn      time.Time
+	disabled      bool // stopped writing after csvMaxErrors consecutive errors
+}
+
+// newCSVSink writes the header to file and starts the writer goroutine
+func newCSVSink(file *os.File, flushInterval time.Duration) *csvSink {
+	s := &csvSink{
+		records:       make(chan csvRecord, csvBufferSize),
+		done:          make(chan struct{}),
+		flushInterval: flushInterval,
+		writer:        csv.NewWriter(file),
+		file:          file,
+	}
+
+	// Write CSV header
+	s.check(s.writer.Write([]string{"timestamp", "tenant_id", "device_id", "publish_latency_ms", "success", "bytes", "warmup", "anomaly"}))
+	s.flush()
+
+	go s.run()
+	return s
+}
+
+// Write queues a record for the writer goroutine; a nil sink drops it
+func (s *csvSink) Write(record csvRecord) {
+	if s == nil {
+		return
+	}
+	s.records <- record
+}
+
+// Errors returns how many CSV writes or flushes have failed; a nil sink has none
+func (s *csvSink) Errors() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.errors.Load()
+}
+
+// Close drains queued records, flushes, and closes the file
+func (s *csvSink) Close() {
+	if s == nil {
+		return
+	}
+	close(s.records)
+	<-s.done
+}
+
+func (s *csvSink) run() {
+	defer close(s.done)
+
+	// The sink stops when Close closes records, after the last publisher
+	// has written, so the final rows are flushed exactly once
+	var tick <-chan time.Time
+	if s.flushInterval > 0 {
+		ticker := time.NewTicker(s.flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	pending := 0
+	for {
+		select {
+		case record, ok := <-s.records:
+			if !ok {
+				if !s.disabled {
+					if pending > 0 {
+						s.flush()
+					}
+					s.file.Close()
+				}
+				return
+			}
+			if s.disabled {
+				continue // keep draining so publishers never block
+			}
+			s.check(s.write(record))
+			if pending++; pending >= csvFlushEvery {
+				s.flush()
+				pending = 0
+			}
+		case <-tick:
+			if pending > 0 && !s.disabled {
+				s.flush()
+				pending = 0
+			}
+		}
+	}
+}
+
+// flush writes buffered rows to the file, recording any error
+func (s *csvSink) flush() {
+	s.writer.Flush()
+	s.check(s.writer.Error())
+}
+
+// check counts a write error with a throttled warning, and gives up on the
+// file after csvMaxErrors in a row so the simulation carries on without it
+func (s *csvSink) check(err error) {
+	if err == nil {
+		s.consecutive = 0
+		return
+	}
+	total := s.errors.Add(1)
+	s.consecutive++
+
+	if time.Since(s.lastWarn) >= csvWarnInterval {
+		log.Printf("⚠️  Failed to write metrics CSV (%d errors so far): %v", total, err)
+		s.lastWarn = time.Now()
+	}
+	if s.consecutive >= csvMaxErrors && !s.disabled {
+		s.disabled = true
+		s.file.Close()
+		log.Printf("❌ Metrics CSV disabled after %d consecutive write errors; simulation continues", s.consecutive)
+	}
+}
+
+func (s *csvSink) write(record csvRecord) error {
+	successStr := "1"
+	if !record.success {
+		successStr = "0"
+	}
+	warmupStr := "0"
+	if record.warmup {
+		warmupStr = "1"
+	}
+	anomalyStr := "0"
+	if record.anomaly {
+		anomalyStr = "1"
+	}
+	return s.writer.Write([]string{
+		record.timestamp.Format(time.RFC3339),
+		record.tenantID,
+		record.deviceID,
+		fmt.Sprintf("%d", record.latencyMs),
+		successStr,
+		fmt.Sprintf("%d", record.bytes),
+		warmupStr,
+		anomalyStr,
+	})
+}
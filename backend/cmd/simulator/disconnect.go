@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+)
+
+// disconnectPolicies are the -on-disconnect values: error keeps publishing
+// (every publish fails until the client reconnects), drop skips readings
+// while disconnected, buffer holds them and publishes them on reconnect
+var disconnectPolicies = map[string]bool{"error": true, "drop": true, "buffer": true}
+
+// ConnectionWatcher is implemented by publishers that know whether their
+// broker connection is currently up, e.g. between a connection loss and
+// the client's automatic reconnect
+type ConnectionWatcher interface {
+	Connected() bool
+}
+
+// heldPublish is a payload held back while the device is disconnected
+type heldPublish struct {
+	topic     string
+	payload   []byte
+	anomalies []bool // one per reading in the payload
+}
+
+// outageBuffer pauses a device's publishing while its broker connection is
+// down (-on-disconnect drop or buffer), rather than failing every tick
+type outageBuffer struct {
+	watcher ConnectionWatcher
+	buffer  bool // false drops readings instead
+	limit   int  // most payloads buffered; the oldest are dropped beyond it
+	held    []heldPublish
+	paused  bool
+}
+
+// newOutageBuffer returns the outage handling for a device's publisher, or
+// nil if the policy is error or the publisher cannot tell it is disconnected
+func newOutageBuffer(publisher Publisher, policy string, limit int) *outageBuffer {
+	if policy == "error" {
+		return nil
+	}
+	watcher, ok := findPublisher[ConnectionWatcher](publisher)
+	if !ok {
+		return nil
+	}
+	return &outageBuffer{watcher: watcher, buffer: policy == "buffer", limit: limit}
+}
+
+// hold reports whether p must wait because the connection is down, in which
+// case it is buffered or dropped
+func (b *outageBuffer) hold(metrics *MetricsTracker, deviceID string, p heldPublish) bool {
+	if b == nil || b.watcher.Connected() {
+		return false
+	}
+
+	if !b.paused {
+		b.paused = true
+		action := "dropping"
+		if b.buffer {
+			action = "buffering"
+		}
+		log.Printf("⏸️  [%s] Broker connection down, %s telemetry until reconnected", deviceID, action)
+	}
+	if !b.buffer {
+		metrics.RecordDisconnectDropped(len(p.anomalies))
+		return true
+	}
+	if len(b.held) >= b.limit {
+		metrics.RecordDisconnectDropped(len(b.held[0].anomalies))
+		b.held = b.held[1:]
+	}
+	b.held = append(b.held, p)
+	metrics.RecordDisconnectBuffered(len(p.anomalies))
+	return true
+}
+
+// resume returns the buffered payloads, oldest first, once the connection
+// is back; nil while still disconnected
+func (b *outageBuffer) resume(deviceID string) []heldPublish {
+	if b == nil || !b.paused || !b.watcher.Connected() {
+		return nil
+	}
+	b.paused = false
+
+	held := b.held
+	b.held = nil
+	if b.buffer {
+		log.Printf("▶️  [%s] Broker connection restored, publishing %d buffered messages", deviceID, len(held))
+	} else {
+		log.Printf("▶️  [%s] Broker connection restored, resuming telemetry", deviceID)
+	}
+	return held
+}
+
+// discard counts the payloads still buffered when the device stops as dropped
+func (b *outageBuffer) discard(metrics *MetricsTracker) {
+	if b == nil {
+		return
+	}
+	for _, p := range b.held {
+		metrics.RecordDisconnectDropped(len(p.anomalies))
+	}
+	b.held = nil
+}
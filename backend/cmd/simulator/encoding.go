@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protobufTopicSuffix marks telemetry topics carrying protobuf payloads
+const protobufTopicSuffix = "/pb"
+
+// payloadEncoding serializes telemetry for the wire. The topic suffix lets
+// subscribers tell encodings apart without inspecting the payload.
+type payloadEncoding struct {
+	topicSuffix string
+	contentType string
+	marshal     func(Telemetry) ([]byte, error)
+	unmarshal   func([]byte, *Telemetry) error
+}
+
+// encodings are the payload formats selectable with -encoding; avro is
+// added once its -avro-schema is loaded
+var encodings = map[string]payloadEncoding{
+	"json": {
+		contentType: "application/json",
+		marshal:     func(t Telemetry) ([]byte, error) { return json.Marshal(t) },
+		unmarshal:   func(data []byte, t *Telemetry) error { return json.Unmarshal(data, t) },
+	},
+	"protobuf": {
+		topicSuffix: protobufTopicSuffix,
+		contentType: "application/x-protobuf",
+		marshal:     marshalTelemetryProto,
+		unmarshal:   unmarshalTelemetryProto,
+	},
+}
+
+// encodeTelemetry serializes t with the configured encoding and optional
+// compression, returning the payload and the topic suffixed to match
+func encodeTelemetry(t Telemetry, topic string, cfg DeviceConfig, metrics *MetricsTracker) (string, []byte, error) {
+	payload, err := cfg.Encoding.marshal(t)
+	if err != nil {
+		return "", nil, err
+	}
+	return compressTelemetry(payload, topic+cfg.Encoding.topicSuffix, cfg, metrics)
+}
+
+// encodeBatch serializes a -burst-mode=array burst as one JSON array,
+// compressed like a single reading
+func encodeBatch(batch []Telemetry, topic string, cfg DeviceConfig, metrics *MetricsTracker) (string, []byte, error) {
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		return "", nil, err
+	}
+	return compressTelemetry(payload, topic, cfg, metrics)
+}
+
+// compressTelemetry gzips an encoded payload if -compress is set
+func compressTelemetry(payload []byte, topic string, cfg DeviceConfig, metrics *MetricsTracker) (string, []byte, error) {
+	if cfg.Compress {
+		compressed, err := gzipPayload(payload)
+		if err != nil {
+			return "", nil, err
+		}
+		metrics.RecordCompression(len(payload), len(compressed))
+		payload = compressed
+		topic += gzipTopicSuffix
+	}
+
+	return topic, payload, nil
+}
+
+// baseTopic strips the encoding and compression suffixes from a telemetry topic
+func baseTopic(topic string) string {
+	topic = strings.TrimSuffix(topic, gzipTopicSuffix)
+	topic = strings.TrimSuffix(topic, avroTopicSuffix)
+	return strings.TrimSuffix(topic, protobufTopicSuffix)
+}
+
+// topicEncoding returns the encoding a telemetry topic carries and whether
+// its payload is gzip-compressed
+func topicEncoding(topic string) (encoding payloadEncoding, compressed bool) {
+	topic, compressed = strings.CutSuffix(topic, gzipTopicSuffix)
+	if strings.HasSuffix(topic, protobufTopicSuffix) {
+		return encodings["protobuf"], compressed
+	}
+	if strings.HasSuffix(topic, avroTopicSuffix) {
+		return encodings["avro"], compressed
+	}
+	return encodings["json"], compressed
+}
+
+// decodeTelemetry parses a payload received on a telemetry topic
+func decodeTelemetry(topic string, payload []byte) (Telemetry, error) {
+	var telemetry Telemetry
+
+	encoding, compressed := topicEncoding(topic)
+	if compressed {
+		var err error
+		if payload, err = gunzipPayload(payload); err != nil {
+			return telemetry, err
+		}
+	}
+
+	err := encoding.unmarshal(payload, &telemetry)
+	return telemetry, err
+}
+
+// marshalTelemetryProto encodes proto/telemetry.proto's Telemetry message
+func marshalTelemetryProto(t Telemetry) ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, t.TenantID)
+	b = appendString(b, 2, t.DeviceID)
+	b = appendString(b, 3, t.Timestamp)
+	if metrics := marshalMetricsProto(t.Metrics); len(metrics) > 0 {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, metrics)
+	}
+	b = appendInt(b, 5, t.BatteryPct)
+	b = appendString(b, 6, t.FWVersion)
+	if t.Seq != 0 {
+		b = protowire.AppendTag(b, 7, protowire.VarintType)
+		b = protowire.AppendVarint(b, t.Seq)
+	}
+	b = appendString(b, 8, t.Padding)
+	return b, nil
+}
+
+// marshalMetricsProto encodes the Metrics message
+func marshalMetricsProto(m Metrics) []byte {
+	var b []byte
+	b = appendInt(b, 1, m.HeartRate)
+	b = appendDouble(b, 2, m.TempC)
+	b = appendInt(b, 3, m.SpO2)
+	b = appendInt(b, 4, m.Steps)
+	b = appendInt(b, 5, m.SystolicMMHG)
+	b = appendInt(b, 6, m.DiastolicMMHG)
+	b = appendInt(b, 7, m.RespRate)
+	b = appendInt(b, 8, m.HRVms)
+	b = appendDouble(b, 9, m.Lat)
+	b = appendDouble(b, 10, m.Lon)
+	if m.FallDetected {
+		b = protowire.AppendTag(b, 11, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	b = appendInt(b, 12, m.GlucoseMGDL)
+	b = appendDouble(b, 13, m.CaloriesKcal)
+	return b
+}
+
+// unmarshalTelemetryProto decodes a Telemetry message, skipping unknown fields
+func unmarshalTelemetryProto(data []byte, t *Telemetry) error {
+	return consumeFields(data, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			t.TenantID = string(value)
+		case num == 2 && typ == protowire.BytesType:
+			t.DeviceID = string(value)
+		case num == 3 && typ == protowire.BytesType:
+			t.Timestamp = string(value)
+		case num == 4 && typ == protowire.BytesType:
+			return unmarshalMetricsProto(value, &t.Metrics)
+		case num == 5 && typ == protowire.VarintType:
+			t.BatteryPct = int(int32(varint))
+		case num == 6 && typ == protowire.BytesType:
+			t.FWVersion = string(value)
+		case num == 7 && typ == protowire.VarintType:
+			t.Seq = varint
+		case num == 8 && typ == protowire.BytesType:
+			t.Padding = string(value)
+		}
+		return nil
+	})
+}
+
+// unmarshalMetricsProto decodes a Metrics message, skipping unknown fields
+func unmarshalMetricsProto(data []byte, m *Metrics) error {
+	ints := map[protowire.Number]*int{
+		1: &m.HeartRate, 3: &m.SpO2, 4: &m.Steps, 5: &m.SystolicMMHG,
+		6: &m.DiastolicMMHG, 7: &m.RespRate, 8: &m.HRVms, 12: &m.GlucoseMGDL,
+	}
+	doubles := map[protowire.Number]*float64{2: &m.TempC, 9: &m.Lat, 10: &m.Lon, 13: &m.CaloriesKcal}
+
+	return consumeFields(data, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		switch {
+		case typ == protowire.VarintType && ints[num] != nil:
+			*ints[num] = int(int32(varint))
+		case typ == protowire.Fixed64Type && doubles[num] != nil:
+			*doubles[num] = math.Float64frombits(varint)
+		case num == 11 && typ == protowire.VarintType:
+			m.FallDetected = varint != 0
+		}
+		return nil
+	})
+}
+
+// consumeFields walks every field of a message. Varint and fixed64 values
+// are passed in varint; length-delimited values in value.
+func consumeFields(data []byte, field func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("invalid protobuf tag: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		var value []byte
+		var varint uint64
+		switch typ {
+		case protowire.VarintType:
+			varint, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			varint, n = protowire.ConsumeFixed64(data)
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return fmt.Errorf("invalid protobuf field %d: %w", num, protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		if err := field(num, typ, value, varint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendString appends a non-empty string field (proto3 omits defaults)
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendInt appends a non-zero int32 field; negatives are sign-extended as proto3 requires
+func appendInt(b []byte, num protowire.Number, v int) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(int64(int32(v))))
+}
+
+// appendDouble appends a non-zero double field
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
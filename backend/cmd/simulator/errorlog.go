@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errorLog rate-limits publish error logging (-error-log-interval), so an
+// outage does not make every device log every interval. The first error of
+// each interval is logged in full; the rest are only counted, by error text,
+// and logged as one summary when the interval ends. Metrics still count
+// every error.
+type errorLog struct {
+	interval time.Duration
+	mu       sync.Mutex
+	logged   bool // an error was logged in full this interval
+	counts   map[string]int64
+	devices  map[string]bool
+}
+
+// newErrorLog returns a rate limiter for one summary per interval; 0 means
+// no limit, every error is logged
+func newErrorLog(interval time.Duration) *errorLog {
+	if interval <= 0 {
+		return nil
+	}
+	return &errorLog{interval: interval, counts: make(map[string]int64), devices: make(map[string]bool)}
+}
+
+// record notes a device's publish error and reports whether it should be
+// logged in full; the others go into the next summary
+func (l *errorLog) record(deviceID string, err error) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.logged {
+		l.logged = true
+		return true
+	}
+	l.counts[err.Error()]++
+	l.devices[deviceID] = true
+	return false
+}
+
+// run logs a summary of the suppressed errors every interval until ctx is
+// cancelled
+func (l *errorLog) run(ctx context.Context) {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.flush()
+		}
+	}
+}
+
+// flush logs the errors suppressed since the last summary, most frequent
+// first, and starts a new interval
+func (l *errorLog) flush() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	counts, devices := l.counts, len(l.devices)
+	l.logged = false
+	l.counts = make(map[string]int64)
+	l.devices = make(map[string]bool)
+	l.mu.Unlock()
+
+	if len(counts) == 0 {
+		return
+	}
+	errs := make([]string, 0, len(counts))
+	var total int64
+	for text, n := range counts {
+		errs = append(errs, text)
+		total += n
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		if counts[errs[i]] != counts[errs[j]] {
+			return counts[errs[i]] > counts[errs[j]]
+		}
+		return errs[i] < errs[j]
+	})
+
+	parts := make([]string, len(errs))
+	for i, text := range errs {
+		parts[i] = fmt.Sprintf("%s (%d)", text, counts[text])
+	}
+	logEvent(slog.LevelError,
+		fmt.Sprintf("❌ %d more publish errors from %d devices: %s", total, devices, strings.Join(parts, ", ")),
+		"publish errors suppressed", "errors", total, "devices", devices, "by_error", counts)
+}
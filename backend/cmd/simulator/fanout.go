@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// fanoutPublisher publishes every message to each of several brokers, e.g.
+// the nodes of a cluster or both ends of a bridge. Brokers are published to
+// concurrently and independently, so one failing broker never holds up or
+// stops the others.
+type fanoutPublisher struct {
+	brokers    []string
+	publishers []*mqttPublisher // one per broker, in the same order
+	metrics    *MetricsTracker  // receives the per-broker outcomes
+}
+
+// connectFanout opens one device connection per broker with connect. A
+// single broker needs no fan-out, so its publisher is returned as is.
+func connectFanout(conns []mqttSettings, metrics *MetricsTracker, connect func(mqttSettings) (*mqttPublisher, error)) (Publisher, error) {
+	if len(conns) == 1 {
+		publisher, err := connect(conns[0])
+		if err != nil {
+			return nil, err
+		}
+		return publisher, nil
+	}
+
+	f := &fanoutPublisher{metrics: metrics}
+	for _, conn := range conns {
+		publisher, err := connect(conn)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", conn.broker, err)
+		}
+		f.brokers = append(f.brokers, conn.broker)
+		f.publishers = append(f.publishers, publisher)
+	}
+	return f, nil
+}
+
+// Publish sends payload to every broker and waits for all of them. The
+// publish fails if any broker failed, naming each one that did.
+func (f *fanoutPublisher) Publish(ctx context.Context, topic string, payload []byte) error {
+	errs := make([]error, len(f.publishers))
+
+	var wg sync.WaitGroup
+	for i, publisher := range f.publishers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			err := publisher.Publish(ctx, topic, payload)
+			if ctx.Err() != nil {
+				return // shutting down; not a broker failure
+			}
+			f.metrics.RecordBrokerPublish(f.brokers[i], time.Since(start).Milliseconds(), err == nil)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", f.brokers[i], err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// PublishRetained sends a retained payload to every broker in turn
+func (f *fanoutPublisher) PublishRetained(ctx context.Context, topic string, payload []byte) error {
+	var errs []error
+	for i, publisher := range f.publishers {
+		if err := publisher.PublishRetained(ctx, topic, payload); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.brokers[i], err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Disconnect drops every broker connection for churn
+func (f *fanoutPublisher) Disconnect() error {
+	var errs []error
+	for _, publisher := range f.publishers {
+		errs = append(errs, publisher.Disconnect())
+	}
+	return errors.Join(errs...)
+}
+
+// Connected reports whether every broker connection is up, as a publish
+// fails while any of them is down
+func (f *fanoutPublisher) Connected() bool {
+	for _, publisher := range f.publishers {
+		if !publisher.Connected() {
+			return false
+		}
+	}
+	return true
+}
+
+// Reconnect re-establishes every broker connection
+func (f *fanoutPublisher) Reconnect() error {
+	var errs []error
+	for i, publisher := range f.publishers {
+		if !publisher.connected {
+			if err := publisher.Reconnect(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", f.brokers[i], err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Shutdown announces the device offline on every broker
+func (f *fanoutPublisher) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, publisher := range f.publishers {
+		errs = append(errs, publisher.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// Online reports whether the device is still connected to any broker
+func (f *fanoutPublisher) Online() bool {
+	for _, publisher := range f.publishers {
+		if publisher.Online() {
+			return true
+		}
+	}
+	return false
+}
+
+// Close disconnects from every broker
+func (f *fanoutPublisher) Close() error {
+	for _, publisher := range f.publishers {
+		publisher.Close()
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FirmwareWeight is one entry of a -fw-versions mix
+type FirmwareWeight struct {
+	Version string
+	Weight  int
+}
+
+// FirmwareMix is the weighted set of firmware versions devices start on
+type FirmwareMix []FirmwareWeight
+
+// FirmwarePlan is the firmware a single device runs: its starting version
+// and, when selected for the rollout, the version it upgrades to and when
+type FirmwarePlan struct {
+	Version   string
+	UpgradeTo string
+	UpgradeAt time.Time // zero = never upgrades
+}
+
+// parseFirmwareVersions parses a comma-separated list of version:weight
+// pairs, e.g. "1.3.2:80,1.4.0:20". A version without a weight counts as 1.
+func parseFirmwareVersions(spec string) (FirmwareMix, error) {
+	var mix FirmwareMix
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		version, weightStr, hasWeight := strings.Cut(entry, ":")
+		weight := 1
+		if hasWeight {
+			w, err := strconv.Atoi(weightStr)
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("firmware weight %q for %s must be a positive integer", weightStr, version)
+			}
+			weight = w
+		}
+		if version == "" {
+			return nil, fmt.Errorf("firmware entry %q has no version", entry)
+		}
+
+		mix = append(mix, FirmwareWeight{Version: version, Weight: weight})
+	}
+	if len(mix) == 0 {
+		return nil, fmt.Errorf("at least one firmware version is required")
+	}
+
+	return mix, nil
+}
+
+// pick chooses a version by weight. A single-version mix draws nothing from
+// rng, so the default fleet keeps the same random sequence as before.
+func (m FirmwareMix) pick(rng *rand.Rand) string {
+	if len(m) == 1 {
+		return m[0].Version
+	}
+
+	total := 0
+	for _, fw := range m {
+		total += fw.Weight
+	}
+
+	n := rng.Intn(total)
+	for _, fw := range m {
+		if n < fw.Weight {
+			return fw.Version
+		}
+		n -= fw.Weight
+	}
+	return m[len(m)-1].Version
+}
+
+// newest returns the highest version in the mix
+func (m FirmwareMix) newest() string {
+	newest := m[0].Version
+	for _, fw := range m[1:] {
+		if compareVersions(fw.Version, newest) > 0 {
+			newest = fw.Version
+		}
+	}
+	return newest
+}
+
+// plan assigns a device its starting firmware and, when rolloutAt is set,
+// selects it for the upgrade to the newest version with probability percent/100
+func (m FirmwareMix) plan(rng *rand.Rand, rolloutAt time.Time, percent float64) FirmwarePlan {
+	plan := FirmwarePlan{Version: m.pick(rng)}
+
+	if rolloutAt.IsZero() {
+		return plan
+	}
+	if newest := m.newest(); plan.Version != newest && rng.Float64()*100 < percent {
+		plan.UpgradeTo = newest
+		plan.UpgradeAt = rolloutAt
+	}
+
+	return plan
+}
+
+// maybeUpgrade applies a scheduled upgrade once now reaches UpgradeAt and
+// reports whether it did
+func (p *FirmwarePlan) maybeUpgrade(now time.Time) bool {
+	if p.UpgradeAt.IsZero() || now.Before(p.UpgradeAt) {
+		return false
+	}
+
+	p.Version = p.UpgradeTo
+	p.UpgradeAt = time.Time{}
+	return true
+}
+
+// compareVersions compares dotted versions numerically, falling back to a
+// string comparison for non-numeric parts
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var ap, bp string
+		if i < len(as) {
+			ap = as[i]
+		}
+		if i < len(bs) {
+			bp = bs[i]
+		}
+
+		an, aErr := strconv.Atoi(ap)
+		bn, bErr := strconv.Atoi(bp)
+		switch {
+		case aErr == nil && bErr == nil && an != bn:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case (aErr != nil || bErr != nil) && ap != bp:
+			return strings.Compare(ap, bp)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Reading is the per-reading input shared by every metric generator
+type Reading struct {
+	Activity float64       // share of the step maximum taken this interval (0..1)
+	Anomaly  bool          // the vitals model's built-in anomaly; false under -anomaly-types
+	Time     time.Time     // when the reading is taken
+	Interval time.Duration // the span of time the reading covers
+	Rng      *rand.Rand
+}
+
+// MetricGenerator contributes its metrics to a reading. Generators run in
+// registry order, so one can build on the values of those before it.
+type MetricGenerator interface {
+	Generate(state *DeviceState, r Reading, m *Metrics)
+}
+
+// generatorFunc adapts a function to MetricGenerator
+type generatorFunc func(state *DeviceState, r Reading, m *Metrics)
+
+func (f generatorFunc) Generate(state *DeviceState, r Reading, m *Metrics) { f(state, r, m) }
+
+// registeredGenerator builds a generator from the run configuration
+type registeredGenerator struct {
+	name    string
+	metrics []string // the JSON fields it fills, advertised by -publish-metadata
+	build   func(cfg *Config) MetricGenerator
+}
+
+// metricGenerators are the generators selectable with -generators, in the
+// order they run. A disabled generator leaves its metrics at zero.
+var metricGenerators = []registeredGenerator{
+	{"vitals", []string{"hr_bpm", "temp_c", "spo2_pct"}, func(cfg *Config) MetricGenerator { return vitalsModels[cfg.VitalsModel] }},
+	{"steps", []string{"steps"}, func(cfg *Config) MetricGenerator {
+		return generatorFunc(func(state *DeviceState, r Reading, m *Metrics) {
+			m.Steps = state.Steps
+		})
+	}},
+	{"bp", []string{"bp_sys", "bp_dia"}, func(cfg *Config) MetricGenerator {
+		exertion := exertionScale(cfg)
+		return generatorFunc(func(state *DeviceState, r Reading, m *Metrics) {
+			m.SystolicMMHG, m.DiastolicMMHG = bloodPressure(state, exertion*r.Activity, r.Rng)
+			if r.Anomaly {
+				hypertensiveCrisis(m, r.Rng)
+			}
+		})
+	}},
+	{"resp", []string{"resp_rate", "hrv_ms"}, func(cfg *Config) MetricGenerator {
+		exertion := exertionScale(cfg)
+		return generatorFunc(func(state *DeviceState, r Reading, m *Metrics) {
+			m.RespRate, m.HRVms = respiration(state, exertion*r.Activity, r.Rng)
+			if r.Anomaly {
+				distress(m, r.Rng)
+			}
+		})
+	}},
+	{"calories", []string{"kcal"}, func(cfg *Config) MetricGenerator {
+		// Derived from the heart rate and steps generated before it
+		return generatorFunc(func(state *DeviceState, r Reading, m *Metrics) {
+			m.CaloriesKcal = caloriesBurned(m.HeartRate, state.BaseHR, r.Activity, r.Interval)
+		})
+	}},
+	{"glucose", []string{"glucose_mgdl"}, func(cfg *Config) MetricGenerator {
+		meals, _ := parseMealTimes(cfg.MealTimes) // validated before building
+		g := GlucoseModel{Meals: meals}
+		return generatorFunc(func(state *DeviceState, r Reading, m *Metrics) {
+			m.GlucoseMGDL = state.glucose(g, r.Time, r.Rng)
+		})
+	}},
+	{"gps", []string{"lat", "lon"}, func(cfg *Config) MetricGenerator {
+		return generatorFunc(func(state *DeviceState, r Reading, m *Metrics) {
+			m.Lat, m.Lon = state.Lat, state.Lon
+		})
+	}},
+}
+
+// defaultGenerators is the -generators default; glucose is added by -glucose
+const defaultGenerators = "vitals,steps,bp,resp,calories,gps"
+
+// exertionScale is how much activity moves blood pressure and breathing:
+// not at all under the independent vitals model
+func exertionScale(cfg *Config) float64 {
+	if cfg.VitalsModel == "independent" {
+		return 0
+	}
+	return 1
+}
+
+// parseGenerators parses the comma-separated -generators list into the set
+// of enabled names
+func parseGenerators(spec string) (map[string]bool, error) {
+	enabled := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !isGenerator(name) {
+			return nil, fmt.Errorf("unknown metric generator %q (want vitals, steps, bp, resp, calories, glucose, or gps)", name)
+		}
+		enabled[name] = true
+	}
+	return enabled, nil
+}
+
+// isGenerator reports whether name is a registered generator
+func isGenerator(name string) bool {
+	for _, g := range metricGenerators {
+		if g.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// enabledGenerators returns the generators enabled by -generators and
+// -glucose, in registry order
+func enabledGenerators(cfg *Config) []registeredGenerator {
+	enabled, _ := parseGenerators(cfg.Generators) // validated above
+	enabled["glucose"] = enabled["glucose"] || cfg.Glucose
+
+	var generators []registeredGenerator
+	for _, g := range metricGenerators {
+		if enabled[g.name] {
+			generators = append(generators, g)
+		}
+	}
+	return generators
+}
+
+// buildGenerators builds the enabled generators
+func buildGenerators(cfg *Config) []MetricGenerator {
+	var generators []MetricGenerator
+	for _, g := range enabledGenerators(cfg) {
+		generators = append(generators, g.build(cfg))
+	}
+	return generators
+}
+
+// generatedMetrics returns the JSON fields the enabled generators fill
+func generatedMetrics(cfg *Config) []string {
+	var metrics []string
+	for _, g := range enabledGenerators(cfg) {
+		metrics = append(metrics, g.metrics...)
+	}
+	return metrics
+}
+
+// generate builds a reading from the enabled generators
+func generate(generators []MetricGenerator, state *DeviceState, r Reading) Metrics {
+	var m Metrics
+	for _, g := range generators {
+		g.Generate(state, r, &m)
+	}
+	return m
+}
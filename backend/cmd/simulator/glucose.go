@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	glucosePeakDelay = 45 * time.Minute // a meal's rise peaks this long after eating
+	glucoseNoiseMGDL = 2.0              // CGM sensor noise, standard deviation
+)
+
+// GlucoseModel drives the CGM reading (-glucose): a per-device fasting
+// baseline of 90-110 mg/dL plus, after each meal, a smooth rise to a
+// 140-180 mg/dL peak that decays over the following hours
+type GlucoseModel struct {
+	Meals []time.Duration // local time of day of each meal
+}
+
+// parseMealTimes parses -meal-times, e.g. "07:30,12:30,19:00"
+func parseMealTimes(spec string) ([]time.Duration, error) {
+	var meals []time.Duration
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		t, err := time.Parse("15:04", part)
+		if err != nil {
+			return nil, fmt.Errorf("meal time %q must be HH:MM", part)
+		}
+		meals = append(meals, time.Duration(t.Hour())*time.Hour+time.Duration(t.Minute())*time.Minute)
+	}
+	return meals, nil
+}
+
+// initGlucose draws the device's fasting baseline and the peak of each meal
+func (s *DeviceState) initGlucose(g GlucoseModel, rng *rand.Rand) {
+	s.GlucoseBase = 90 + rng.Float64()*20
+	s.GlucosePeaks = make([]float64, len(g.Meals))
+	for i := range s.GlucosePeaks {
+		s.GlucosePeaks[i] = 140 + rng.Float64()*40
+	}
+}
+
+// glucose returns the reading at now: the baseline plus what is left of
+// every meal's rise
+func (s *DeviceState) glucose(g GlucoseModel, now time.Time, rng *rand.Rand) int {
+	local := now.Local()
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+
+	level := s.GlucoseBase
+	for i, meal := range g.Meals {
+		// A meal not yet eaten today still decays from yesterday's, e.g. a late dinner past midnight
+		at := midnight.Add(meal)
+		if at.After(local) {
+			at = at.AddDate(0, 0, -1)
+		}
+		level += (s.GlucosePeaks[i] - s.GlucoseBase) * mealResponse(local.Sub(at))
+	}
+	return int(math.Round(level + rng.NormFloat64()*glucoseNoiseMGDL))
+}
+
+// mealResponse is the share of a meal's peak rise present dt after eating:
+// 0 at the meal, 1 at glucosePeakDelay, then decaying to about a fifth
+// after three hours
+func mealResponse(dt time.Duration) float64 {
+	x := dt.Seconds() / glucosePeakDelay.Seconds()
+	return x * math.Exp(1-x)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// healthServer serves liveness and readiness probes for orchestrators
+// (-health-addr). /healthz answers 200 as soon as the simulator is up;
+// /readyz answers 200 once every device is connected, and 503 while any
+// client has lost its broker connection.
+type healthServer struct {
+	server  *http.Server
+	started atomic.Bool // every device client connected
+	metrics *MetricsTracker
+}
+
+// newHealthServer creates the probe server on addr
+func newHealthServer(addr string, metrics *MetricsTracker) *healthServer {
+	h := &healthServer{metrics: metrics}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", h.ready)
+	h.server = &http.Server{Addr: addr, Handler: mux}
+	return h
+}
+
+// Run serves probes until ctx is cancelled, then shuts the server down
+func (h *healthServer) Run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		h.server.Shutdown(shutdownCtx)
+	}()
+
+	if err := h.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("❌ Health server error: %v", err)
+	}
+}
+
+// SetStarted marks startup complete, once every device client is connected
+func (h *healthServer) SetStarted() {
+	h.started.Store(true)
+}
+
+func (h *healthServer) ready(w http.ResponseWriter, _ *http.Request) {
+	switch {
+	case !h.started.Load():
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+	case h.metrics.DisconnectedClients() > 0:
+		http.Error(w, fmt.Sprintf("%d clients reconnecting", h.metrics.DisconnectedClients()), http.StatusServiceUnavailable)
+	default:
+		fmt.Fprintln(w, "ready")
+	}
+}
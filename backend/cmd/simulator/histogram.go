@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLatencyBuckets are the -latency-buckets boundaries in ms
+const defaultLatencyBuckets = "1,5,10,50,100,500"
+
+// histogramBarWidth is the length of the longest bar PrintStats draws
+const histogramBarWidth = 40
+
+// LatencyBucket counts successful publishes with latency in [MinMs, MaxMs);
+// MaxMs is -1 for the open-ended last bucket
+type LatencyBucket struct {
+	MinMs int64 `json:"min_ms"`
+	MaxMs int64 `json:"max_ms"`
+	Count int64 `json:"count"`
+}
+
+// Label renders the bucket range, e.g. "10-50" or "500+"
+func (b LatencyBucket) Label() string {
+	if b.MaxMs < 0 {
+		return fmt.Sprintf("%d+", b.MinMs)
+	}
+	return fmt.Sprintf("%d-%d", b.MinMs, b.MaxMs)
+}
+
+// parseLatencyBuckets parses comma-separated, strictly increasing bucket
+// boundaries in ms, e.g. "1,5,10,50,100,500"
+func parseLatencyBuckets(spec string) ([]int64, error) {
+	var bounds []int64
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		bound, err := strconv.ParseInt(field, 10, 64)
+		if err != nil || bound <= 0 {
+			return nil, fmt.Errorf("latency bucket %q must be a positive integer", field)
+		}
+		if len(bounds) > 0 && bound <= bounds[len(bounds)-1] {
+			return nil, fmt.Errorf("latency buckets must be strictly increasing, got %d after %d", bound, bounds[len(bounds)-1])
+		}
+		bounds = append(bounds, bound)
+	}
+	if len(bounds) == 0 {
+		return nil, fmt.Errorf("at least one latency bucket boundary is required")
+	}
+	return bounds, nil
+}
+
+// bucketIndex returns the histogram bucket a latency falls into
+func bucketIndex(bounds []int64, latencyMs int64) int {
+	return sort.Search(len(bounds), func(i int) bool { return latencyMs < bounds[i] })
+}
+
+// histogram turns bucket counts into LatencyBuckets
+func histogram(bounds, counts []int64) []LatencyBucket {
+	buckets := make([]LatencyBucket, len(counts))
+	lower := int64(0)
+	for i, count := range counts {
+		upper := int64(-1)
+		if i < len(bounds) {
+			upper = bounds[i]
+		}
+		buckets[i] = LatencyBucket{MinMs: lower, MaxMs: upper, Count: count}
+		lower = upper
+	}
+	return buckets
+}
+
+// printHistogram draws buckets as an ASCII bar chart scaled to the largest count
+func printHistogram(buckets []LatencyBucket) {
+	var largest, total int64
+	for _, b := range buckets {
+		largest = max(largest, b.Count)
+		total += b.Count
+	}
+	if total == 0 {
+		return
+	}
+
+	fmt.Fprintln(reportOut, "Latency Histogram:")
+	for _, b := range buckets {
+		bar := int(b.Count * histogramBarWidth / largest)
+		if bar == 0 && b.Count > 0 {
+			bar = 1 // keep non-empty buckets visible
+		}
+		fmt.Fprintf(reportOut, "  %10s ms %-*s %d (%.1f%%)\n", b.Label(), histogramBarWidth, strings.Repeat("#", bar),
+			b.Count, float64(b.Count)*100/float64(total))
+	}
+}
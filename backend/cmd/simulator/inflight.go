@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// inflightLimiter bounds the publishes outstanding across all devices
+// (-max-inflight) with a buffered channel used as a semaphore
+type inflightLimiter struct {
+	slots   chan struct{}
+	metrics *MetricsTracker
+}
+
+// newInflightLimiter allows up to max concurrent publishes
+func newInflightLimiter(max int, metrics *MetricsTracker) *inflightLimiter {
+	return &inflightLimiter{slots: make(chan struct{}, max), metrics: metrics}
+}
+
+// acquire takes a slot, recording how long the caller blocked if none was free
+func (l *inflightLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	start := time.Now()
+	select {
+	case l.slots <- struct{}{}:
+		l.metrics.RecordInflightBlock(time.Since(start))
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *inflightLimiter) release() {
+	<-l.slots
+}
+
+// limitedPublisher holds an in-flight slot for the duration of each publish
+type limitedPublisher struct {
+	Publisher
+	limiter *inflightLimiter
+}
+
+// Publish waits for a free slot, then publishes; the slot is released once
+// the broker acknowledges or the publish fails
+func (p *limitedPublisher) Publish(ctx context.Context, topic string, payload []byte) error {
+	if err := p.limiter.acquire(ctx); err != nil {
+		return err
+	}
+	defer p.limiter.release()
+	return p.Publisher.Publish(ctx, topic, payload)
+}
+
+// Unwrap returns the wrapped publisher
+func (p *limitedPublisher) Unwrap() Publisher {
+	return p.Publisher
+}
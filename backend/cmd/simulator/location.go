@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	metersPerDegreeLat = 111320.0
+	walkingSpeedMPS    = 1.4 // typical walking pace at full activity
+)
+
+// GPSModel bounds device movement to a circle around a home point
+type GPSModel struct {
+	HomeLat float64
+	HomeLon float64
+	RadiusM float64
+}
+
+// initLocation places the device at a random point within the radius of home
+func (s *DeviceState) initLocation(gps GPSModel, rng *rand.Rand) {
+	// sqrt keeps the starting points uniform over the disc rather than clustered at home
+	distance := gps.RadiusM * math.Sqrt(rng.Float64())
+	bearing := rng.Float64() * 2 * math.Pi
+	s.Lat, s.Lon = gps.offset(gps.HomeLat, gps.HomeLon, distance*math.Cos(bearing), distance*math.Sin(bearing))
+}
+
+// walk moves the device one random step. Resting devices only jitter by
+// GPS noise; active devices cover ground at up to walking pace. A step that
+// would leave the radius is turned back toward home.
+func (s *DeviceState) walk(gps GPSModel, activity float64, interval time.Duration, rng *rand.Rand) {
+	step := 1.0 + walkingSpeedMPS*interval.Seconds()*activity*rng.Float64()
+	bearing := rng.Float64() * 2 * math.Pi
+	northM, eastM := step*math.Cos(bearing), step*math.Sin(bearing)
+
+	lat, lon := gps.offset(s.Lat, s.Lon, northM, eastM)
+	if gps.distanceFromHome(lat, lon) > gps.RadiusM {
+		lat, lon = gps.offset(s.Lat, s.Lon, -northM, -eastM)
+		if gps.distanceFromHome(lat, lon) > gps.RadiusM {
+			return
+		}
+	}
+	s.Lat, s.Lon = lat, lon
+}
+
+// offset returns the point northM/eastM meters away from lat/lon
+func (g GPSModel) offset(lat, lon, northM, eastM float64) (float64, float64) {
+	return lat + northM/metersPerDegreeLat,
+		lon + eastM/(metersPerDegreeLat*math.Cos(lat*math.Pi/180))
+}
+
+// distanceFromHome approximates the distance in meters using an
+// equirectangular projection, accurate enough at geofence scale
+func (g GPSModel) distanceFromHome(lat, lon float64) float64 {
+	northM := (lat - g.HomeLat) * metersPerDegreeLat
+	eastM := (lon - g.HomeLon) * metersPerDegreeLat * math.Cos(g.HomeLat*math.Pi/180)
+	return math.Hypot(northM, eastM)
+}
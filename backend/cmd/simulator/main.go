@@ -2,198 +2,1290 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"math"
 	"math/rand"
+	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// telemetryTimeFormat is RFC3339 with millisecond precision, fine enough to
+// measure end-to-end latency from the embedded timestamp
+const telemetryTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// timestampFormats render the telemetry ts field, selectable with -ts-format
+var timestampFormats = map[string]func(time.Time) string{
+	"rfc3339":     func(t time.Time) string { return t.UTC().Format(telemetryTimeFormat) },
+	"rfc3339nano": func(t time.Time) string { return t.UTC().Format(time.RFC3339Nano) },
+	"unix-millis": func(t time.Time) string { return strconv.FormatInt(t.UnixMilli(), 10) },
+}
+
+// parseTelemetryTime parses a ts field in any -ts-format
+func parseTelemetryTime(value string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	return time.Parse(time.RFC3339, value) // also accepts fractional seconds
+}
+
 // Telemetry represents device sensor data
 type Telemetry struct {
 	TenantID   string    `json:"tenant_id"`
 	DeviceID   string    `json:"device_id"`
 	Timestamp  string    `json:"ts"`
+	Seq        uint64    `json:"seq"` // per-device message counter, from 0
 	Metrics    Metrics   `json:"metrics"`
 	BatteryPct int       `json:"battery_pct"`
 	FWVersion  string    `json:"fw_version"`
+	Padding    string    `json:"padding,omitempty"` // -payload-padding-bytes filler
 }
 
 type Metrics struct {
-	HeartRate int     `json:"hr_bpm"`
-	TempC     float64 `json:"temp_c"`
-	SpO2      int     `json:"spo2_pct"`
-	Steps     int     `json:"steps"`
+	HeartRate     int     `json:"hr_bpm"`
+	TempC         float64 `json:"temp_c"`
+	SpO2          int     `json:"spo2_pct"`
+	Steps         int     `json:"steps"`
+	SystolicMMHG  int     `json:"bp_sys"`
+	DiastolicMMHG int     `json:"bp_dia"`
+	RespRate      int     `json:"resp_rate"`
+	HRVms         int     `json:"hrv_ms"`
+	Lat           float64 `json:"lat"`
+	Lon           float64 `json:"lon"`
+	FallDetected  bool    `json:"fall"`
+	CaloriesKcal  float64 `json:"kcal"`                   // estimated energy burned over the interval
+	GlucoseMGDL   int     `json:"glucose_mgdl,omitempty"` // -glucose only
+}
+
+// DeviceConfig holds the run settings shared by every device goroutine
+type DeviceConfig struct {
+	Interval              time.Duration
+	BatteryDrainPerHour   float64
+	BatteryRecharge       bool
+	StepsPerIntervalMax   int
+	Generators            []MetricGenerator // -generators, in registry order
+	Scenario              *ScenarioEngine
+	Live                  *atomic.Pointer[liveParams] // SIGHUP-reloadable settings; nil = fixed
+	Baseline              DeviceOverride
+	Profile               Profile // zero = the default baseline ranges
+	ProfileName           string  // empty = no profile
+	PublishMetadata       bool
+	Capabilities          []string // metrics fields reported, for -publish-metadata
+	Firmware              FirmwarePlan
+	ChurnRate             float64 // probability per minute that the device drops offline
+	ChurnBackoff          time.Duration
+	GPS                   GPSModel
+	Glucose               *GlucoseModel // nil = no glucose reading
+	Bounds                *MetricBounds // nil = no clamping
+	FallProbability       float64       // chance per reading that a fall event fires
+	Circadian             bool
+	ClockDrift            time.Duration // maximum drift of a device's ts per hour; 0 = true time
+	AnomalyRate           float64       // chance per reading of an anomaly
+	AnomalyTypes          []string      // nil = the vitals model's built-in anomaly
+	AnomalyExcluded       bool          // outside -anomaly-devices: never anomalous
+	Index                 int           // position in the fleet, for device selectors
+	AnomalyDuration       time.Duration // 0 = each anomaly lasts one reading
+	AnomalyDurationJitter float64
+	AnomalyTypeRates      []AnomalyRate // drawn independently of AnomalyRate
+	Compress              bool
+	PaddingBytes          int // filler appended to each payload
+	Encoding              payloadEncoding
+	FormatTime            func(time.Time) string // -ts-format
+	Recorders             []*telemetryRecorder   // -record file and -stdout-ndjson stream
+	States                *deviceStateStore      // -state-file; nil = devices start fresh
+	PublishTimeout        time.Duration
+	Jitter                float64       // fraction of Interval each tick may vary by
+	Burst                 int           // readings sent per tick
+	InjectErrorRate       float64       // chance per publish of a deliberate failure
+	BurstMode             string        // "separate" publishes or one JSON "array"
+	Rate                  *adaptiveRate // -adaptive-rate controller; nil = fixed rate
+	ErrorLog              *errorLog     // -error-log-interval limiter; nil = log every error
+	OnDisconnect          string        // -on-disconnect policy
+	DisconnectBuffer      int
+	Schedule              *Schedule // active windows; nil = always active
+	Topics                *TopicTemplate
 }
 
 var globalMetrics *MetricsTracker
 
 func main() {
 	// Command-line flags
-	broker := flag.String("broker", "tcp://localhost:1883", "MQTT broker URL")
-	numDevices := flag.Int("devices", 5, "Number of simulated devices")
-	interval := flag.Duration("interval", 2*time.Second, "Publishing interval")
-	tenantID := flag.String("tenant", "acme-clinic", "Tenant ID")
-	duration := flag.Duration("duration", 0, "Test duration (0 = infinite)")
-	metricsFile := flag.String("metrics", "simulator-metrics.csv", "Metrics output file")
+	cfg := &Config{}
+	configFile, showVersion := registerFlags(flag.CommandLine, cfg)
 	flag.Parse()
+	if *showVersion {
+		fmt.Println("HealthSense Simulator", readBuildInfo())
+		return
+	}
+
+	if *configFile != "" {
+		if err := loadConfigFile(*configFile, cfg, flag.CommandLine); err != nil {
+			log.Fatalf("❌ Failed to load config: %v", err)
+		}
+	}
+	setupLogging(cfg.LogFormat)
+	if cfg.DryRun || cfg.StdoutNDJSON {
+		reportOut = os.Stderr // keep the stdout stream clean
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("❌ Invalid configuration:\n%v", err)
+	}
+
+	// Without an explicit seed, derive one from the clock so the run can still be reproduced
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
 
-	log.Printf("🚀 Starting HealthSense Simulator")
-	log.Printf("   Broker: %s", *broker)
-	log.Printf("   Devices: %d", *numDevices)
-	log.Printf("   Interval: %v", *interval)
-	log.Printf("   Tenant: %s", *tenantID)
-	if *duration > 0 {
-		log.Printf("   Duration: %v", *duration)
+	var baseline map[string]interface{}
+	if cfg.Baseline != "" {
+		b, err := loadBaseline(cfg.Baseline)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		baseline = b
+	}
+
+	if cfg.Encoding == "avro" {
+		enc, err := loadAvroEncoding(cfg.AvroSchema)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		encodings["avro"] = enc
+	}
+
+	// Offline analysis of an earlier run's CSV replaces the simulation
+	if cfg.AnalyzeFile != "" {
+		buckets, _ := parseLatencyBuckets(cfg.LatencyBuckets) // validated above
+		stats, err := runAnalyze(cfg, buckets)
+		if err != nil {
+			log.Fatalf("❌ Analysis failed: %v", err)
+		}
+		if baseline != nil {
+			if err := checkBaseline(cfg, stats, baseline); err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+		}
+		if slo := cfg.SLO(); slo.enabled() {
+			if err := checkSLO(slo, stats); err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+		}
+		return
+	}
+
+	// Set by checks that fail the run; deferred first so it runs after every other defer
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
+	tenantIDs := cfg.TenantIDs()
+	if jsonLogs {
+		slog.Info("simulator starting", "seed", cfg.Seed, "build", readBuildInfo(), "config", resolvedFlags())
+	} else {
+		log.Printf("🚀 Starting HealthSense Simulator %s", readBuildInfo())
+		switch {
+		case cfg.DryRun:
+			log.Printf("   Dry run: writing payloads to stdout, not connecting")
+		case cfg.Transport == "http":
+			log.Printf("   HTTP Endpoint: %s", cfg.HTTPEndpoint)
+		default:
+			log.Printf("   Broker: %s", cfg.Broker)
+		}
+		log.Printf("   Devices: %d", cfg.Devices)
+		log.Printf("   Interval: %v", cfg.Interval)
+		if cfg.ClockDrift > 0 {
+			log.Printf("   Clock Drift: up to ±%v per hour", cfg.ClockDrift)
+		}
+		if cfg.Jitter > 0 {
+			log.Printf("   Jitter: ±%.0f%%", cfg.Jitter*100)
+		}
+		if cfg.Burst > 1 {
+			log.Printf("   Burst: %d readings per tick (%s)", cfg.Burst, cfg.BurstMode)
+		}
+		if cfg.PayloadPaddingBytes > 0 {
+			log.Printf("   Payload Padding: %d bytes", cfg.PayloadPaddingBytes)
+		}
+		log.Printf("   Tenants: %s", strings.Join(tenantIDs, ", "))
+		log.Printf("   QoS: %d", cfg.QoS)
+		if cfg.Retained {
+			// Retained messages are normally reserved for status topics
+			log.Printf("   Retained: true (broker keeps the last telemetry message per device)")
+		}
+		if !cfg.CleanSession {
+			log.Printf("   Clean Session: false (persistent sessions)")
+		}
+		if cfg.Transport == "mqtt" && cfg.MQTTVersion == "5" {
+			if cfg.MessageExpiry > 0 {
+				log.Printf("   MQTT Version: 5 (message expiry %v)", cfg.MessageExpiry)
+			} else {
+				log.Printf("   MQTT Version: 5")
+			}
+		}
+		log.Printf("   Seed: %d", cfg.Seed)
+		if cfg.NoCSV || cfg.MetricsFile == "" {
+			log.Printf("   Metrics CSV: disabled (in-memory stats only)")
+		}
+		if cfg.Duration > 0 {
+			log.Printf("   Duration: %v", cfg.Duration)
+		}
+		if cfg.MaxMessages > 0 {
+			log.Printf("   Max Messages: %d", cfg.MaxMessages)
+		}
+		if cfg.RampUp > 0 {
+			log.Printf("   Ramp-up: %v", cfg.RampUp)
+		}
+		if cfg.Warmup > 0 {
+			log.Printf("   Warmup: %v", cfg.Warmup)
+		}
+		if cfg.MaxInflight > 0 {
+			log.Printf("   Max In-Flight: %d", cfg.MaxInflight)
+		}
+		if cfg.AdaptiveRate {
+			log.Printf("   Adaptive Rate: P95 threshold %v, up to %.0fx slower", cfg.AdaptiveP95, cfg.AdaptiveMaxSlowdown)
+		}
+	}
+
+	if cfg.Manifest != "" {
+		if err := writeManifest(cfg.Manifest, cfg, time.Now()); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		log.Printf("📝 Run manifest written to %s", cfg.Manifest)
 	}
 
 	// Initialize metrics
 	var err error
-	globalMetrics, err = NewMetrics(*metricsFile)
+	latencyBuckets, _ := parseLatencyBuckets(cfg.LatencyBuckets) // validated above
+	metricsFile := cfg.MetricsFile
+	if cfg.NoCSV {
+		metricsFile = ""
+	}
+	globalMetrics, err = NewMetrics(metricsFile, MetricsOptions{
+		QoS:               byte(cfg.QoS),
+		LatencySampleSize: cfg.LatencySampleSize,
+		Seed:              cfg.Seed,
+		Warmup:            cfg.Warmup,
+		MaxMessages:       cfg.MaxMessages,
+		LatencyBuckets:    latencyBuckets,
+		Shards:            cfg.MetricsShards,
+		CSVFlushInterval:  cfg.CSVFlushInterval,
+	})
 	if err != nil {
 		log.Fatalf("❌ Failed to initialize metrics: %v", err)
 	}
 	defer globalMetrics.Flush()
+	globalMetrics.SetRunConfig(resolvedFlags())
 
-	// Start metrics reporter
-	go metricsReporter()
+	// Optional Prometheus endpoint
+	if cfg.PrometheusAddr != "" {
+		registry := prometheus.NewRegistry()
+		if err := globalMetrics.RegisterPrometheus(registry); err != nil {
+			log.Fatalf("❌ Failed to register Prometheus metrics: %v", err)
+		}
 
-	// MQTT client options
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(*broker)
-	opts.SetClientID(fmt.Sprintf("simulator-%d", time.Now().Unix()))
-	opts.SetKeepAlive(60 * time.Second)
-	opts.SetPingTimeout(10 * time.Second)
-	opts.SetAutoReconnect(true)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		promServer := &http.Server{Addr: cfg.PrometheusAddr, Handler: mux}
+		go func() {
+			if err := promServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("❌ Prometheus server error: %v", err)
+			}
+		}()
+		defer promServer.Close()
+		log.Printf("📈 Prometheus metrics on %s/metrics", cfg.PrometheusAddr)
+	}
+
+	// MQTT connection settings shared by every device client
+	brokers := cfg.Brokers()
+	conn := mqttSettings{
+		broker:         brokers[0],
+		clientIDPrefix: cfg.ClientIDPrefix,
+		keepAlive:      cfg.KeepAlive,
+		pingTimeout:    cfg.PingTimeout,
+		connectTimeout: cfg.ConnectTimeout,
+		connectRetries: cfg.ConnectRetries,
+		cleanSession:   cfg.CleanSession,
+		qos:            byte(cfg.QoS),
+		metrics:        globalMetrics,
+	}
+
+	// Broker authentication
+	if cfg.Username != "" {
+		conn.username = cfg.Username
+
+		conn.password = cfg.Password
+		if conn.password == "" {
+			conn.password = os.Getenv("HEALTHSENSE_MQTT_PASSWORD")
+		}
+		if conn.password == "" {
+			log.Printf("⚠️  Username set but no password provided (-password or HEALTHSENSE_MQTT_PASSWORD)")
+		}
+	}
 
-	// Connect to broker
-	client := mqtt.NewClient(opts)
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		log.Fatalf("❌ Failed to connect to broker: %v", token.Error())
+	if cfg.MQTTVersion != "5" && cfg.PingTimeout >= cfg.KeepAlive {
+		log.Printf("⚠️  Ping timeout %v should be less than keepalive %v", cfg.PingTimeout, cfg.KeepAlive)
 	}
-	log.Printf("✅ Connected to MQTT broker")
+
+	// TLS configuration: applied for TLS schemes (ssl, tls, mqtts, wss), using
+	// the system roots unless cert flags are provided. paho ignores it for
+	// plain tcp/ws brokers.
+	var secureSchemes, plainSchemes []string
+	for _, broker := range brokers {
+		scheme, secure, _ := brokerScheme(broker) // validated above
+		if secure && !slices.Contains(secureSchemes, scheme) {
+			secureSchemes = append(secureSchemes, scheme)
+		} else if !secure && !slices.Contains(plainSchemes, scheme) {
+			plainSchemes = append(plainSchemes, scheme)
+		}
+	}
+	tlsFlags := cfg.CACert != "" || cfg.ClientCert != "" || cfg.ClientKey != "" || cfg.InsecureSkipVerify
+	if tlsFlags && len(secureSchemes) == 0 && cfg.Transport == "mqtt" {
+		log.Printf("⚠️  TLS flags ignored for %s:// brokers; use ssl://, mqtts:// or wss:// to enable TLS", strings.Join(plainSchemes, ":// "))
+	}
+	if len(secureSchemes) > 0 {
+		tlsConfig, err := buildTLSConfig(cfg.CACert, cfg.ClientCert, cfg.ClientKey, cfg.InsecureSkipVerify)
+		if err != nil {
+			log.Fatalf("❌ Failed to configure TLS: %v", err)
+		}
+		conn.tlsConfig = tlsConfig
+		log.Printf("🔒 TLS enabled (%s://)", strings.Join(secureSchemes, ":// "))
+	}
+
+	topics, _ := parseTopicTemplate(cfg.TopicTemplate) // validated above
 
 	// Wait group for graceful shutdown
 	var wg sync.WaitGroup
 	ctx, cancel := context.WithCancel(context.Background())
 
+	// Listen for interrupts before connecting so Ctrl-C can stop connection
+	// attempts and ramp-up; the wait below takes over once startup is done
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	startupDone := make(chan struct{})
+	go func() {
+		select {
+		case <-sigChan:
+			log.Println("🛑 Received interrupt signal during startup...")
+			cancel()
+		case <-startupDone:
+		}
+	}()
+
+	// Optional subscriber that confirms delivery of every publish
+	var verifier *Verifier
+	if cfg.Verify {
+		verifier, err = newVerifier(ctx, conn, globalMetrics, cfg.ClockSkew, cfg.VerifyProcessingDelay, topics.Subscription())
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Fatalf("❌ Failed to start verifier: %v", err)
+		}
+		defer verifier.Close()
+		log.Printf("🔍 Verifying delivery on %s", topics.Subscription())
+	}
+
+	// Start metrics reporter; it stops with ctx
+	reporterDone := make(chan struct{})
+	go func() {
+		metricsReporter(ctx)
+		close(reporterDone)
+	}()
+
+	// Optional control loop that backs the publish rate off under saturation
+	var rate *adaptiveRate
+	if cfg.AdaptiveRate {
+		rate = newAdaptiveRate(cfg.AdaptiveP95, cfg.AdaptiveMaxSlowdown)
+		globalMetrics.TrackRecentLatencies(adaptiveRecentLatencies)
+		go rate.run(ctx, globalMetrics, cfg.Interval)
+	}
+
+	// Publish errors are summarized rather than logged per device and interval
+	errLog := newErrorLog(cfg.ErrorLogInterval)
+	if errLog != nil {
+		go errLog.run(ctx)
+	}
+
+	// Optional liveness/readiness probes; the server stops with ctx
+	var health *healthServer
+	if cfg.HealthAddr != "" {
+		health = newHealthServer(cfg.HealthAddr, globalMetrics)
+		go health.Run(ctx)
+		log.Printf("🩺 Health probes on %s/healthz and /readyz", cfg.HealthAddr)
+	}
+
 	// If duration is set, auto-cancel after duration
-	if *duration > 0 {
+	if cfg.Duration > 0 {
 		go func() {
-			time.Sleep(*duration)
-			log.Println("⏰ Test duration reached, shutting down...")
+			time.Sleep(cfg.Duration)
+			logEvent(slog.LevelInfo, "⏰ Test duration reached, shutting down...", "test duration reached", "duration", cfg.Duration.String())
 			cancel()
 		}()
 	}
 
-	// Start device goroutines
-	for i := 0; i < *numDevices; i++ {
+	deviceConfig := DeviceConfig{
+		Interval:              cfg.Interval,
+		BatteryDrainPerHour:   cfg.BatteryDrainPerHour,
+		BatteryRecharge:       cfg.BatteryRecharge,
+		StepsPerIntervalMax:   cfg.StepsPerIntervalMax,
+		Generators:            buildGenerators(cfg),
+		PublishMetadata:       cfg.PublishMetadata,
+		Capabilities:          generatedMetrics(cfg),
+		ChurnRate:             cfg.ChurnRate,
+		ChurnBackoff:          cfg.ChurnBackoff,
+		FallProbability:       cfg.FallProbability,
+		Circadian:             cfg.Circadian,
+		ClockDrift:            cfg.ClockDrift,
+		AnomalyRate:           cfg.AnomalyRate,
+		AnomalyDuration:       cfg.AnomalyDuration,
+		AnomalyDurationJitter: cfg.AnomalyDurationJitter,
+		PublishTimeout:        cfg.PublishTimeout,
+		Jitter:                cfg.Jitter,
+		Burst:                 cfg.Burst,
+		InjectErrorRate:       cfg.InjectErrorRate,
+		BurstMode:             cfg.BurstMode,
+		Rate:                  rate,
+		ErrorLog:              errLog,
+		OnDisconnect:          cfg.OnDisconnect,
+		DisconnectBuffer:      cfg.DisconnectBuffer,
+		Topics:                topics,
+		Compress:              cfg.Compress,
+		PaddingBytes:          cfg.PayloadPaddingBytes,
+		Encoding:              encodings[cfg.Encoding],
+		FormatTime:            timestampFormats[cfg.TimestampFormat],
+		GPS: GPSModel{
+			HomeLat: cfg.HomeLat,
+			HomeLon: cfg.HomeLon,
+			RadiusM: cfg.GPSRadiusM,
+		},
+	}
+
+	if enabled, _ := parseGenerators(cfg.Generators); cfg.Glucose || enabled["glucose"] {
+		meals, _ := parseMealTimes(cfg.MealTimes) // validated above
+		deviceConfig.Glucose = &GlucoseModel{Meals: meals}
+	}
+	deviceConfig.Bounds, _ = parseMetricBounds(cfg.Bounds)                     // validated above
+	deviceConfig.AnomalyTypes, _ = parseAnomalyTypes(cfg.AnomalyTypes)         // validated above
+	deviceConfig.AnomalyTypeRates, _ = parseAnomalyRates(cfg.AnomalyTypeRates) // validated above
+
+	if cfg.StateFile != "" {
+		states, err := loadDeviceStates(cfg.StateFile)
+		if err != nil {
+			log.Fatalf("❌ Failed to load device state: %v", err)
+		}
+		if n := states.Len(); n > 0 {
+			log.Printf("💾 Restoring %d devices from %s", n, cfg.StateFile)
+		}
+		if verifier != nil {
+			verifier.Resume(states.Seqs())
+		}
+		deviceConfig.States = states
+	}
+
+	if cfg.RecordFile != "" {
+		recorder, err := newTelemetryRecorder(cfg.RecordFile)
+		if err != nil {
+			log.Fatalf("❌ Failed to start recording: %v", err)
+		}
+		defer recorder.Close()
+		deviceConfig.Recorders = append(deviceConfig.Recorders, recorder)
+		log.Printf("⏺️  Recording telemetry to %s", cfg.RecordFile)
+	}
+	if cfg.StdoutNDJSON {
+		recorder := newStdoutRecorder()
+		defer recorder.Close()
+		deviceConfig.Recorders = append(deviceConfig.Recorders, recorder)
+	}
+
+	// Scripted events and the firmware rollout are timed from the moment devices start
+	runStart := time.Now()
+	if cfg.ScenarioFile != "" {
+		scenario, err := LoadScenario(cfg.ScenarioFile, runStart)
+		if err != nil {
+			log.Fatalf("❌ Failed to load scenario: %v", err)
+		}
+		deviceConfig.Scenario = scenario
+		log.Printf("🎬 Scenario loaded from %s", cfg.ScenarioFile)
+	}
+
+	// SIGHUP re-reads the -config and -scenario files into the running fleet
+	if *configFile != "" || cfg.ScenarioFile != "" {
+		deviceConfig.Live = &atomic.Pointer[liveParams]{}
+		deviceConfig.Live.Store(&liveParams{
+			AnomalyRate:      cfg.AnomalyRate,
+			AnomalyTypes:     deviceConfig.AnomalyTypes,
+			AnomalyTypeRates: deviceConfig.AnomalyTypeRates,
+			FallProbability:  cfg.FallProbability,
+			Scenario:         deviceConfig.Scenario,
+		})
+		reload := &reloader{current: *cfg, configFile: *configFile, runStart: runStart, live: deviceConfig.Live}
+		hupChan := make(chan os.Signal, 1)
+		signal.Notify(hupChan, syscall.SIGHUP)
+		go reload.run(ctx, hupChan)
+	}
+
+	profiles := mergedProfiles(cfg.DeviceProfiles)
+	profileOf, _ := profileAssignments(profiles)             // validated above
+	profileMix, _ := parseProfileMix(cfg.Profiles, profiles) // validated above
+	if len(profileOf) > 0 {
+		log.Printf("👥 %d devices assigned to profiles explicitly", len(profileOf))
+	}
+	if len(profileMix) > 0 {
+		log.Printf("👥 Profile mix for remaining devices: %s", cfg.Profiles)
+	}
+
+	anomalyDevices, _ := parseDeviceSelector(cfg.AnomalyDevices) // validated above
+	if anomalyDevices != nil {
+		log.Printf("🎯 Anomalies limited to devices %s", cfg.AnomalyDevices)
+	}
+
+	fwMix, _ := parseFirmwareVersions(cfg.FWVersions) // validated above
+	var fwRolloutAt time.Time
+	if cfg.FWRolloutDuration > 0 {
+		fwRolloutAt = runStart.Add(cfg.FWRolloutDuration)
+		log.Printf("📦 Firmware rollout to %s after %v (%.0f%% of older devices)", fwMix.newest(), cfg.FWRolloutDuration, cfg.FWRolloutPercent)
+	}
+
+	// Start device goroutines, staggered across the ramp-up window.
+	// HTTP and dry-run devices share one publisher; MQTT devices each connect.
+	var sharedPub Publisher
+	switch {
+	case cfg.DryRun:
+		sharedPub = newDryRunPublisher(os.Stdout)
+	case cfg.Transport == "http":
+		sharedPub = newHTTPPublisher(cfg.HTTPEndpoint, cfg.PublishTimeout)
+	}
+
+	// Device connections per broker; several brokers fan every publish out
+	brokerConns := conn.forBrokers(brokers)
+	if len(brokerConns) > 1 && sharedPub == nil {
+		log.Printf("📡 Fanning out to %d brokers: %s", len(brokers), strings.Join(brokers, ", "))
+	}
+
+	// -max-inflight bounds outstanding publishes across the whole fleet
+	var limiter *inflightLimiter
+	if cfg.MaxInflight > 0 {
+		limiter = newInflightLimiter(cfg.MaxInflight, globalMetrics)
+	}
+
+	// Every device publisher, shut down together once the devices stop
+	var publishers []Publisher
+
+	// Replay mode publishes recorded telemetry in place of the generated fleet
+	if cfg.ReplayFile != "" {
+		publisher := sharedPub
+		if publisher == nil {
+			mqttPub, err := connectFanout(brokerConns, globalMetrics, func(s mqttSettings) (*mqttPublisher, error) {
+				return s.replayPublisher(ctx, cfg.Retained, cfg.PublishTimeout)
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Fatalf("❌ Failed to connect replay client to broker: %v", err)
+			}
+			publisher = mqttPub
+		}
+		if verifier != nil {
+			publisher = &verifyingPublisher{Publisher: publisher, verifier: verifier}
+		}
+		if limiter != nil {
+			publisher = &limitedPublisher{Publisher: publisher, limiter: limiter}
+		}
+
+		publishers = append(publishers, publisher)
+		log.Printf("▶️  Replaying %s at %.1fx speed", cfg.ReplayFile, cfg.ReplaySpeed)
 		wg.Add(1)
+		go func() {
+			if err := replayTelemetry(ctx, &wg, publisher, globalMetrics, cfg.ReplayFile, cfg.ReplaySpeed, deviceConfig); err != nil {
+				log.Printf("❌ Replay failed: %v", err)
+			} else if ctx.Err() == nil {
+				log.Println("⏹️  Replay finished, shutting down...")
+			}
+			cancel()
+		}()
+		cfg.Devices = 0
+	}
+
+	var rampStep time.Duration
+	if cfg.RampUp > 0 && cfg.Devices > 0 {
+		rampStep = cfg.RampUp / time.Duration(cfg.Devices)
+	}
+
+startup:
+	for i := 0; i < cfg.Devices; i++ {
+		if i > 0 && rampStep > 0 {
+			select {
+			case <-ctx.Done():
+				break startup
+			case <-time.After(rampStep):
+			}
+		}
+
 		deviceID := fmt.Sprintf("watch-%04d", i)
-		go publishTelemetry(ctx, &wg, client, *tenantID, deviceID, *interval)
+		// Devices are distributed round-robin across tenants
+		tenantID := tenantIDs[i%len(tenantIDs)]
+
+		publisher := sharedPub
+		if publisher == nil {
+			// Each device gets its own connection so the broker can publish its will
+			var mqttPub Publisher
+			var err error
+			if cfg.MQTTVersion == "5" {
+				mqttPub, err = brokerConns[0].device5Publisher(ctx, tenantID, deviceID, cfg.Retained, cfg.PublishTimeout, cfg.MessageExpiry)
+			} else {
+				mqttPub, err = connectFanout(brokerConns, globalMetrics, func(s mqttSettings) (*mqttPublisher, error) {
+					return s.devicePublisher(ctx, tenantID, deviceID, cfg.Retained, cfg.PublishTimeout)
+				})
+			}
+			if ctx.Err() != nil {
+				break startup
+			}
+			if err != nil {
+				log.Fatalf("❌ [%s] Failed to connect to broker: %v", deviceID, err)
+			}
+			publisher = mqttPub
+		}
+		if verifier != nil {
+			publisher = &verifyingPublisher{Publisher: publisher, verifier: verifier}
+		}
+		if limiter != nil {
+			publisher = &limitedPublisher{Publisher: publisher, limiter: limiter}
+		}
+		publishers = append(publishers, publisher)
+
+		wg.Add(1)
+		// Each device owns its *rand.Rand so goroutines never share a source
+		rng := rand.New(rand.NewSource(deviceSeed(cfg.Seed, i)))
+		devCfg := deviceConfig
+		devCfg.Index = i
+		devCfg.AnomalyExcluded = !anomalyDevices.Matches(deviceID, i)
+		devCfg.Baseline = cfg.DeviceOverrides[deviceID]
+		name, ok := profileOf[deviceID]
+		if !ok && len(profileMix) > 0 {
+			name, ok = profileMix.pick(rng), true
+		}
+		if ok {
+			profiles[name].apply(&devCfg)
+			devCfg.ProfileName = name
+		}
+		if devCfg.Baseline.Schedule != nil {
+			devCfg.Schedule = devCfg.Baseline.Schedule
+		}
+		devCfg.Firmware = fwMix.plan(rng, fwRolloutAt, cfg.FWRolloutPercent)
+		go publishTelemetry(ctx, &wg, publisher, globalMetrics, tenantID, deviceID, devCfg, rng)
+	}
+
+	close(startupDone)
+	if health != nil && ctx.Err() == nil {
+		health.SetStarted()
+	}
+	if sharedPub == nil && cfg.Devices > 0 {
+		logEvent(slog.LevelInfo, fmt.Sprintf("✅ Connected %d device clients to MQTT broker", cfg.Devices),
+			"devices connected", "devices", cfg.Devices, "broker", cfg.Broker)
 	}
 
 	// Wait for interrupt signal
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	
 	select {
 	case <-sigChan:
-		log.Println("🛑 Received interrupt signal...")
+		logEvent(slog.LevelInfo, "🛑 Received interrupt signal...", "shutting down", "reason", "signal")
+	case <-globalMetrics.LimitReached():
+		logEvent(slog.LevelInfo, fmt.Sprintf("🔢 Published %d messages, shutting down...", cfg.MaxMessages),
+			"max messages reached", "max_messages", cfg.MaxMessages)
 	case <-ctx.Done():
-		log.Println("🛑 Context cancelled...")
+		logEvent(slog.LevelInfo, "🛑 Context cancelled...", "shutting down", "reason", "context cancelled")
 	}
 
 	cancel()
 	wg.Wait()
-	client.Disconnect(250)
+	<-reporterDone
+	errLog.flush()
+	if deviceConfig.States != nil {
+		if err := deviceConfig.States.Write(cfg.StateFile); err != nil {
+			log.Printf("❌ Failed to save device state: %v", err)
+		} else {
+			log.Printf("💾 Device state saved to %s", cfg.StateFile)
+		}
+	}
+	shutdownPublishers(publishers, cfg.ShutdownTimeout)
+	if verifier != nil {
+		verifier.Wait(verifyGrace)
+		globalMetrics.RecordVerification(verifier.Stats())
+	}
 	
 	// Print final metrics
 	globalMetrics.PrintStats()
+	if verifier != nil {
+		verifier.PrintStats()
+	}
+	if cfg.PerDeviceReport {
+		globalMetrics.PrintPerDeviceStats()
+	}
+	if cfg.MetricsJSON != "" {
+		if err := globalMetrics.WriteJSON(cfg.MetricsJSON); err != nil {
+			log.Printf("❌ Failed to write metrics JSON: %v", err)
+		} else {
+			log.Printf("💾 Metrics JSON written to %s", cfg.MetricsJSON)
+		}
+	}
+	if baseline != nil {
+		if err := checkBaseline(cfg, globalMetrics.GetStats(), baseline); err != nil {
+			log.Printf("❌ %v", err)
+			exitCode = 1
+		}
+	}
+	if slo := cfg.SLO(); slo.enabled() {
+		if err := checkSLO(slo, globalMetrics.GetStats()); err != nil {
+			log.Printf("❌ %v", err)
+			exitCode = 1
+		}
+	}
 	log.Println("✅ Simulator stopped")
 }
 
-func publishTelemetry(ctx context.Context, wg *sync.WaitGroup, client mqtt.Client, tenantID, deviceID string, interval time.Duration) {
+// registerFlags defines every command-line flag on fs, bound to cfg, and
+// returns the -config path and -version. A SIGHUP reload registers them
+// again on a fresh set to rebuild the configuration the same way startup did.
+func registerFlags(fs *flag.FlagSet, cfg *Config) (configFile *string, showVersion *bool) {
+	cfg.Broker = "tcp://localhost:1883"
+	fs.Var(&brokerFlag{target: &cfg.Broker}, "broker", "MQTT broker URL: tcp://, ssl://, mqtts://, or ws:// and wss:// for MQTT over WebSocket; repeat or comma-separate to publish to several brokers")
+	fs.StringVar(&cfg.Transport, "transport", "mqtt", "Telemetry transport: mqtt or http")
+	fs.StringVar(&cfg.HTTPEndpoint, "http-endpoint", "http://localhost:8080/ingest", "Base URL for -transport=http (topic path is appended)")
+	fs.IntVar(&cfg.Devices, "devices", 5, "Number of simulated devices")
+	fs.DurationVar(&cfg.Interval, "interval", 2*time.Second, "Publishing interval")
+	fs.StringVar(&cfg.Tenant, "tenant", "acme-clinic", "Tenant ID")
+	fs.StringVar(&cfg.Tenants, "tenants", "", "Comma-separated tenant IDs, or a count of IDs generated from -tenant")
+	fs.Int64Var(&cfg.MaxMessages, "max-messages", 0, "Stop after publishing this many messages in total (0 = unlimited)")
+	fs.DurationVar(&cfg.Duration, "duration", 0, "Test duration (0 = infinite)")
+	fs.StringVar(&cfg.MetricsFile, "metrics", "simulator-metrics.csv", "Per-publish metrics CSV (empty = none)")
+	fs.DurationVar(&cfg.CSVFlushInterval, "csv-flush-interval", time.Second, "Flush buffered metrics CSV rows to disk at least this often, bounding what a crash loses (0 = every 1000 rows only)")
+	fs.BoolVar(&cfg.NoCSV, "no-csv", false, "Skip the per-publish metrics CSV and keep only in-memory stats, for maximum throughput")
+	fs.IntVar(&cfg.QoS, "qos", 1, "MQTT QoS level (0, 1, or 2)")
+	fs.BoolVar(&cfg.CleanSession, "clean-session", true, "Start every MQTT session clean; false resumes persistent sessions for QoS 1/2 redelivery")
+	fs.StringVar(&cfg.MQTTVersion, "mqtt-version", "3.1.1", "MQTT protocol version: 3.1.1, or 5 to tag each publish with tenant_id and device_id user properties")
+	fs.StringVar(&cfg.OnDisconnect, "on-disconnect", "error", "While a device's broker connection is down: error (keep publishing, each publish fails), drop (skip readings) or buffer (publish them on reconnect)")
+	fs.IntVar(&cfg.DisconnectBuffer, "disconnect-buffer", 1000, "Most messages each device buffers with -on-disconnect buffer; the oldest are dropped beyond it")
+	fs.DurationVar(&cfg.MessageExpiry, "message-expiry", 0, "MQTT 5 message expiry: the broker drops undelivered telemetry older than this (0 = never)")
+	fs.DurationVar(&cfg.KeepAlive, "keepalive", 60*time.Second, "MQTT keepalive interval")
+	fs.DurationVar(&cfg.ConnectTimeout, "connect-timeout", 10*time.Second, "Timeout for each broker connection attempt")
+	fs.IntVar(&cfg.ConnectRetries, "connect-retries", 5, "Connection attempts retried with exponential backoff before giving up, e.g. while a broker starts")
+	fs.DurationVar(&cfg.PingTimeout, "ping-timeout", defaultPingTimeout, "How long to wait for a keepalive ping response before the connection is considered lost")
+	fs.StringVar(&cfg.ClientIDPrefix, "client-id-prefix", "sim", "Prefix of the deterministic MQTT client IDs, <prefix>-<tenant>-<device>; use distinct prefixes for concurrent simulators")
+	fs.BoolVar(&cfg.PublishMetadata, "publish-metadata", false, "Publish a retained device registration message (model, firmware, profile, capabilities) to tenants/<tenant>/devices/<device>/meta as each device starts")
+	fs.BoolVar(&cfg.Retained, "retained", false, "Publish telemetry as retained so new subscribers get the last value (the broker stores one message per device topic)")
+	fs.StringVar(&cfg.Encoding, "encoding", "json", "Payload encoding: json, protobuf (published on <topic>"+protobufTopicSuffix+") or avro (on <topic>"+avroTopicSuffix+")")
+	fs.StringVar(&cfg.AvroSchema, "avro-schema", "", "Avro .avsc schema for -encoding=avro, e.g. proto/telemetry.avsc")
+	fs.StringVar(&cfg.TimestampFormat, "ts-format", "rfc3339", "Telemetry ts format: rfc3339 (millisecond precision), rfc3339nano, or unix-millis")
+	fs.IntVar(&cfg.PayloadPaddingBytes, "payload-padding-bytes", 0, "Append a padding field of this many bytes to every telemetry message, e.g. to simulate diagnostic blobs")
+	fs.BoolVar(&cfg.Compress, "compress", false, "Gzip telemetry payloads and publish them on <topic>"+gzipTopicSuffix)
+	fs.Float64Var(&cfg.Jitter, "jitter", 0, "Randomize each device's publish interval within +/- this fraction (0-1)")
+	fs.Float64Var(&cfg.InjectErrorRate, "inject-error-rate", 0, "Chance per publish to skip it and record a failure instead, for testing error-rate alerting")
+	fs.IntVar(&cfg.Burst, "burst", 1, "Readings each device sends per tick, like a device flushing a batch")
+	fs.StringVar(&cfg.BurstMode, "burst-mode", "separate", "How a -burst is sent: separate (one publish per reading) or array (one JSON array publish)")
+	fs.BoolVar(&cfg.AdaptiveRate, "adaptive-rate", false, "Slow every device down while recent P95 publish latency exceeds -adaptive-p95, and speed back up as it recovers (AIMD)")
+	fs.DurationVar(&cfg.AdaptiveP95, "adaptive-p95", 200*time.Millisecond, "P95 publish latency above which -adaptive-rate slows down")
+	fs.Float64Var(&cfg.AdaptiveMaxSlowdown, "adaptive-max-slowdown", 10, "Largest factor -adaptive-rate may stretch the interval by")
+	fs.IntVar(&cfg.MaxInflight, "max-inflight", 0, "Maximum publishes outstanding across all devices (0 = unlimited)")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 5*time.Second, "Maximum time to spend announcing devices offline at shutdown")
+	fs.StringVar(&cfg.TopicTemplate, "topic-template", defaultTopicTemplate, "Go text/template for telemetry topics, with .TenantID and .DeviceID")
+	fs.DurationVar(&cfg.PublishTimeout, "publish-timeout", 5*time.Second, "Maximum time to wait for a publish to complete")
+	fs.StringVar(&cfg.CACert, "ca-cert", "", "CA certificate file for verifying the broker")
+	fs.StringVar(&cfg.ClientCert, "client-cert", "", "Client certificate file for mutual TLS")
+	fs.StringVar(&cfg.ClientKey, "client-key", "", "Client private key file for mutual TLS")
+	fs.BoolVar(&cfg.InsecureSkipVerify, "insecure-skip-verify", false, "Skip broker certificate verification (dev only)")
+	fs.StringVar(&cfg.Username, "username", "", "MQTT username")
+	fs.StringVar(&cfg.Password, "password", "", "MQTT password (prefer HEALTHSENSE_MQTT_PASSWORD)")
+	fs.Int64Var(&cfg.Seed, "seed", 0, "Random seed for reproducible runs (0 = random)")
+	fs.StringVar(&cfg.MetricsJSON, "metrics-json", "", "Write final aggregated stats as JSON to this file")
+	fs.StringVar(&cfg.StateFile, "state-file", "", "Restore device baselines, battery, steps and sequence numbers from this JSON file at startup and save them at shutdown")
+	fs.StringVar(&cfg.Manifest, "manifest", "", "Write a JSON manifest of the run's resolved configuration, seed, host and build to this file at startup")
+	fs.StringVar(&cfg.Baseline, "baseline", "", "Compare the final stats against this -metrics-json file from an earlier run")
+	fs.BoolVar(&cfg.FailOnRegression, "fail-on-regression", false, "Exit non-zero if throughput or P95 latency regressed against -baseline beyond tolerance")
+	fs.Float64Var(&cfg.MaxThroughputDrop, "max-throughput-drop", 0.1, "Largest tolerated throughput drop against -baseline, as a fraction")
+	fs.Float64Var(&cfg.MaxP95Increase, "max-p95-increase", 0.2, "Largest tolerated P95 latency increase against -baseline, as a fraction")
+	fs.Int64Var(&cfg.SLOP95Ms, "slo-p95-ms", 0, "Exit non-zero if the run's P95 publish latency exceeds this many ms (0 = no SLO)")
+	fs.Float64Var(&cfg.SLOErrorRate, "slo-error-rate", -1, "Exit non-zero if more than this fraction of publishes failed, e.g. 0.01 (0 = none may fail, -1 = no SLO)")
+	fs.StringVar(&cfg.LatencyBuckets, "latency-buckets", defaultLatencyBuckets, "Comma-separated latency histogram bucket boundaries in ms")
+	fs.IntVar(&cfg.LatencySampleSize, "latency-sample-size", 100000, "Max latencies kept for percentiles; beyond this a reservoir sample makes them approximate")
+	fs.IntVar(&cfg.MetricsShards, "metrics-shards", 16, "Independent metrics locks devices are spread across, reducing contention in large fleets (1 = a single lock)")
+	fs.BoolVar(&cfg.PerDeviceReport, "per-device-report", false, "Print a per-device stats table at shutdown")
+	fs.DurationVar(&cfg.RampUp, "rampup", 0, "Spread device startup evenly over this window (0 = start all at once)")
+	fs.DurationVar(&cfg.Warmup, "warmup", 0, "Initial period whose publishes are reported separately from the steady-state stats")
+	fs.Float64Var(&cfg.BatteryDrainPerHour, "battery-drain-per-hour", 5, "Battery percentage drained per hour")
+	fs.BoolVar(&cfg.BatteryRecharge, "battery-recharge", false, "Reset battery to 100% when depleted instead of going offline")
+	fs.StringVar(&cfg.VitalsModel, "vitals-model", "independent", "Vitals generator: independent or correlated")
+	fs.StringVar(&cfg.Generators, "generators", defaultGenerators, "Metric generators to run: vitals (hr, temp, spo2), steps, bp, resp (resp_rate, hrv), calories (kcal), glucose, gps; metrics left out are reported as 0")
+	fs.IntVar(&cfg.StepsPerIntervalMax, "steps-per-interval-max", 50, "Maximum steps added per interval while active")
+	fs.StringVar(&cfg.FWVersions, "fw-versions", "1.3.2", "Weighted firmware versions devices start on, e.g. 1.3.2:80,1.4.0:20")
+	fs.DurationVar(&cfg.FWRolloutDuration, "fw-rollout-duration", 0, "Upgrade devices to the newest -fw-versions entry this long into the run (0 = no rollout)")
+	fs.Float64Var(&cfg.FWRolloutPercent, "fw-rollout-percent", 50, "Percentage of devices on older firmware that upgrade during the rollout")
+	fs.Float64Var(&cfg.ChurnRate, "churn-rate", 0, "Fraction of devices per minute that disconnect and later reconnect (0 = no churn)")
+	fs.DurationVar(&cfg.ChurnBackoff, "churn-backoff", 30*time.Second, "Maximum time a churned device stays offline")
+	fs.Float64Var(&cfg.HomeLat, "home-lat", 42.3601, "Latitude devices start around")
+	fs.Float64Var(&cfg.HomeLon, "home-lon", -71.0589, "Longitude devices start around")
+	fs.Float64Var(&cfg.GPSRadiusM, "gps-radius-m", 1000, "Radius in meters that devices wander within around home")
+	fs.BoolVar(&cfg.Glucose, "glucose", false, "Report CGM glucose (glucose_mgdl) with a post-meal rise and decay after each -meal-times")
+	fs.StringVar(&cfg.MealTimes, "meal-times", "07:30,12:30,19:00", "Local meal times driving -glucose spikes, as comma-separated HH:MM")
+	fs.StringVar(&cfg.Bounds, "bounds", "", "Clamp readings to plausible limits, as metric=min:max for hr, spo2 and temp (defaults hr=25:230,spo2=0:100,temp=30:43; off = no clamping)")
+	fs.BoolVar(&cfg.Circadian, "circadian", false, "Vary baseline heart rate, temperature and activity with a day/night cycle")
+	fs.Float64Var(&cfg.AnomalyRate, "anomaly-rate", 0.1, "Chance per reading that a device reports an anomaly")
+	fs.StringVar(&cfg.AnomalyDevices, "anomaly-devices", "", "Limit anomalies to these devices: comma-separated IDs, index ranges such as 0-9, or a fleet percentage such as 10% (empty = every device)")
+	fs.DurationVar(&cfg.AnomalyDuration, "anomaly-duration", 0, "Keep a triggered anomaly active on every reading for this long before returning to baseline (0 = one reading)")
+	fs.Float64Var(&cfg.AnomalyDurationJitter, "anomaly-duration-jitter", 0.25, "Randomize each -anomaly-duration within +/- this fraction (0-1)")
+	fs.StringVar(&cfg.AnomalyTypeRates, "anomaly-type-rates", "", "Anomalies drawn on their own with a per-reading chance, independent of -anomaly-rate, e.g. hypoxia:0.02")
+	fs.StringVar(&cfg.Profiles, "profiles", "", "Weighted patient profiles for devices not assigned one in the config, e.g. athlete:20,elderly:50,febrile:30")
+	fs.StringVar(&cfg.AnomalyTypes, "anomaly-types", "", "Comma-separated anomalies to pick from: tachycardia, bradycardia, hypoxia, fever, hypothermia (empty = the vitals model's fever with tachycardia)")
+	fs.Float64Var(&cfg.FallProbability, "fall-probability", 0.001, "Chance per reading that a device reports a fall")
+	fs.StringVar(&cfg.ScenarioFile, "scenario", "", "JSON timeline of scripted per-device events")
+	fs.StringVar(&cfg.RecordFile, "record", "", "Save every generated message to this JSONL file (replayable with -replay)")
+	fs.StringVar(&cfg.AnalyzeFile, "analyze", "", "Recompute and print stats from a metrics CSV instead of running a simulation")
+	fs.StringVar(&cfg.FilterDevice, "filter-device", "", "With -analyze, only include this device ID")
+	fs.StringVar(&cfg.Since, "since", "", "With -analyze, only include rows at or after this RFC3339 time")
+	fs.StringVar(&cfg.Until, "until", "", "With -analyze, only include rows at or before this RFC3339 time")
+	fs.StringVar(&cfg.ReplayFile, "replay", "", "Republish telemetry from this JSONL file instead of generating devices")
+	fs.Float64Var(&cfg.ReplaySpeed, "replay-speed", 1, "Replay timing multiplier (2 = twice as fast as recorded)")
+	fs.StringVar(&cfg.HealthAddr, "health-addr", "", "Serve /healthz and /readyz probes on this address, e.g. :8081 (empty = disabled)")
+	fs.StringVar(&cfg.PrometheusAddr, "prometheus-addr", "", "Serve Prometheus /metrics on this address (e.g. :9090)")
+	fs.StringVar(&cfg.LogFormat, "log-format", "text", "Log output format: text (human-readable) or json (structured, for log aggregators)")
+	fs.DurationVar(&cfg.ErrorLogInterval, "error-log-interval", time.Second, "Log at most one publish error per interval and summarize the rest by error, so outages do not flood the log (0 = log every error)")
+	fs.BoolVar(&cfg.DryRun, "dry-run", false, "Write generated payloads to stdout instead of connecting to a broker")
+	fs.BoolVar(&cfg.StdoutNDJSON, "stdout-ndjson", false, "Also stream every generated message to stdout as newline-delimited JSON, e.g. for jq; reports move to stderr")
+	fs.BoolVar(&cfg.Verify, "verify", false, "Subscribe to the published telemetry and report delivery rate and end-to-end latency")
+	fs.DurationVar(&cfg.VerifyProcessingDelay, "verify-processing-delay", 0, "Simulate a slow consumer: the -verify subscriber holds each message this long before acknowledging it, and reports the lag and backlog")
+	fs.DurationVar(&cfg.ClockDrift, "clock-drift", 0, "Let each device's reported ts run fast or slow against true time by a rate drawn up to this much per hour, e.g. 2s (0 = exact clocks)")
+	fs.DurationVar(&cfg.ClockSkew, "clock-skew", 0, "Added to end-to-end latency to correct for clock offset between publisher and subscriber (-verify)")
+	configFile = fs.String("config", "", "YAML config file (flags passed explicitly override it)")
+	showVersion = fs.Bool("version", false, "Print the simulator version and exit")
+	return configFile, showVersion
+}
+
+// resolvedFlags returns every flag with its effective value, secrets redacted
+func resolvedFlags() map[string]string {
+	values := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if f.Name == "password" && value != "" {
+			value = "REDACTED"
+		}
+		if f.Name == "broker" {
+			value = strings.Join(redactedBrokers(&Config{Broker: value}), ",")
+		}
+		values[f.Name] = value
+	})
+	return values
+}
+
+// deviceSeed derives a distinct, deterministic sub-seed for a device from the base seed
+func deviceSeed(base int64, index int) int64 {
+	return base ^ int64(uint64(index+1)*0x9E3779B97F4A7C15)
+}
+
+// publishTelemetry runs one simulated device until ctx is cancelled. The
+// publisher and metrics tracker are injected so the loop can run against fakes.
+// The caller owns the publisher and shuts it down once the device stops.
+func publishTelemetry(ctx context.Context, wg *sync.WaitGroup, publisher Publisher, metrics *MetricsTracker, tenantID, deviceID string, cfg DeviceConfig, rng *rand.Rand) {
 	defer wg.Done()
 
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	// Each tick is scheduled from the previous due time, so with no jitter
+	// the cadence matches a fixed ticker
+	lastTick := time.Now()
+	due := lastTick.Add(jitteredInterval(cfg.interval(), cfg.Jitter, rng))
+	timer := time.NewTimer(time.Until(due))
+	defer timer.Stop()
 
 	// Initialize baseline vitals
-	baseHR := 70 + rand.Intn(30)
-	baseTemp := 36.5 + rand.Float64()
-	baseSpO2 := 95 + rand.Intn(5)
-	steps := 0
+	state := newDeviceState(cfg.Profile, cfg.Baseline, rng)
+	state.initLocation(cfg.GPS, rng)
+	if cfg.Glucose != nil {
+		state.initGlucose(*cfg.Glucose, rng)
+	}
+	if cfg.Circadian {
+		state.CircadianShift = time.Duration((rng.Float64()*2 - 1) * circadianShiftMaxMin * float64(time.Minute))
+	}
+	clock := deviceClock{start: time.Now()} // true time unless -clock-drift
+	if cfg.ClockDrift > 0 {
+		clock = newDeviceClock(cfg.ClockDrift, rng)
+	}
+	firmware := cfg.Firmware
+	var seq uint64 // lets subscribers detect gaps and reordering
+
+	// A restored device continues where the previous run left it
+	if cfg.States != nil {
+		if saved, ok := cfg.States.Restore(deviceID); ok {
+			*state = saved.State
+			seq = saved.Seq
+			if cfg.Glucose != nil && len(state.GlucosePeaks) != len(cfg.Glucose.Meals) {
+				state.initGlucose(*cfg.Glucose, rng) // -meal-times changed since
+			}
+		}
+		defer func() { cfg.States.Save(deviceID, *state, seq) }()
+	}
+	if cfg.PublishMetadata {
+		publishMetadata(ctx, publisher, tenantID, deviceID, cfg)
+	}
+	padding := randomPadding(cfg.PaddingBytes, rng)
+	idle := false // outside the device's schedule
+	outage := newOutageBuffer(publisher, cfg.OnDisconnect, cfg.DisconnectBuffer)
+	defer outage.discard(metrics)
+	var lastHeartbeat time.Time
+
+	// send publishes one payload carrying the given readings, recording each
+	// of them with the latency since start; false means the device must stop
+	send := func(topic string, payload []byte, anomalies []bool, start time.Time) bool {
+		if outage.hold(metrics, deviceID, heldPublish{topic: topic, payload: payload, anomalies: anomalies}) {
+			return true
+		}
+		if !metrics.ReservePublishes(len(anomalies)) {
+			return false // -max-messages budget spent; main shuts down once the last publish is recorded
+		}
+		// -inject-error-rate fails the publish without it reaching the broker
+		injected := cfg.InjectErrorRate > 0 && rng.Float64() < cfg.InjectErrorRate
+		var publishErr error
+		var latencyMs int64
+		if injected {
+			publishErr = errInjectedPublish
+		} else {
+			publishErr = publisher.Publish(ctx, topic, payload)
+			if ctx.Err() != nil {
+				metrics.ForfeitPublishes(len(anomalies))
+				return false
+			}
+			latencyMs = time.Since(start).Milliseconds()
+		}
+		success := publishErr == nil
+
+		// Record metrics
+		for _, anomaly := range anomalies {
+			metrics.RecordPublish(tenantID, deviceID, latencyMs, len(payload)/len(anomalies), success, anomaly)
+			if injected {
+				metrics.RecordInjectedError()
+			}
+		}
+
+		if !success && cfg.ErrorLog.record(deviceID, publishErr) {
+			logEvent(slog.LevelError, fmt.Sprintf("❌ [%s] Publish error: %v", deviceID, publishErr),
+				"publish failed", "device_id", deviceID, "tenant_id", tenantID, "topic", topic,
+				"latency_ms", latencyMs, "error", publishErr.Error())
+		}
+		return true
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
+		case <-timer.C:
 			startTime := time.Now()
+			// Time-based drift (battery, churn, GPS walk) follows the time
+			// actually elapsed, which jitter and -adaptive-rate move off -interval
+			elapsed := startTime.Sub(lastTick)
+			lastTick = startTime
+			due = due.Add(jitteredInterval(cfg.interval(), cfg.Jitter, rng))
+			if due.Before(startTime) {
+				// Fell behind; fire once immediately rather than bursting to catch up
+				due = startTime
+			}
+			timer.Reset(time.Until(due))
+			cfg.refresh()
+
+			// Readings buffered during a connection loss go out first, in order
+			for _, held := range outage.resume(deviceID) {
+				if !send(held.topic, held.payload, held.anomalies, time.Now()) {
+					return
+				}
+			}
 
-			// Generate telemetry
-			telemetry := Telemetry{
-				TenantID:  tenantID,
-				DeviceID:  deviceID,
-				Timestamp: time.Now().UTC().Format(time.RFC3339),
-				Metrics: Metrics{
-					HeartRate: baseHR + rand.Intn(21) - 10,
-					TempC:     baseTemp + (rand.Float64()*0.4 - 0.2),
-					SpO2:      baseSpO2 + rand.Intn(3) - 1,
-					Steps:     steps + rand.Intn(50),
-				},
-				BatteryPct: 100 - rand.Intn(30),
-				FWVersion:  "1.3.2",
+			// Outside its schedule the device idles, at most sending heartbeats
+			if cfg.Schedule != nil && !cfg.Schedule.Active(startTime) {
+				if !idle {
+					idle = true
+					log.Printf("💤 [%s] Outside schedule, idling", deviceID)
+				}
+				if every := cfg.Schedule.heartbeatInterval(); every > 0 && startTime.Sub(lastHeartbeat) >= every {
+					lastHeartbeat = startTime
+					sendHeartbeat(ctx, publisher, metrics, tenantID, deviceID)
+				}
+				continue
+			}
+			if idle {
+				idle = false
+				log.Printf("⏰ [%s] Schedule window open, resuming telemetry", deviceID)
 			}
-			steps = telemetry.Metrics.Steps
 
-			// Occasionally simulate anomalies (10% chance)
-			if rand.Float32() < 0.1 {
-				telemetry.Metrics.HeartRate = 150 + rand.Intn(30)
-				telemetry.Metrics.TempC = 38.0 + rand.Float64()
+			// Drain battery (+/-20% noise, never increases)
+			state.Battery -= cfg.BatteryDrainPerHour * elapsed.Hours() * (0.8 + rng.Float64()*0.4)
+			if state.Battery <= 0 {
+				if !cfg.BatteryRecharge {
+					logEvent(slog.LevelWarn, fmt.Sprintf("🪫 [%s] Battery depleted, device going offline", deviceID),
+						"battery depleted", "device_id", deviceID, "tenant_id", tenantID, "recharge", false)
+					offlineCtx, cancelOffline := context.WithTimeout(context.Background(), cfg.PublishTimeout)
+					defer cancelOffline()
+					if err := shutdownPublisher(offlineCtx, publisher); err != nil {
+						log.Printf("⚠️  [%s] Failed to announce offline status: %v", deviceID, err)
+					}
+					return
+				}
+				logEvent(slog.LevelInfo, fmt.Sprintf("🔋 [%s] Battery depleted, recharged to 100%%", deviceID),
+					"battery depleted", "device_id", deviceID, "tenant_id", tenantID, "recharge", true)
+				state.Battery = 100
 			}
 
-			// Publish
-			topic := fmt.Sprintf("tenants/%s/devices/%s/telemetry", tenantID, deviceID)
-			payload, _ := json.Marshal(telemetry)
+			// Churn: drop offline for a while, then resume on the next tick
+			if cfg.ChurnRate > 0 && rng.Float64() < cfg.ChurnRate*elapsed.Minutes() {
+				if !churn(ctx, publisher, metrics, deviceID, cfg.ChurnBackoff, rng) {
+					return
+				}
+				lastTick = time.Now() // the time offline is not a tick's worth of drift
+				continue
+			}
 
-			token := client.Publish(topic, 1, false, payload)
-			token.Wait()
+			if from := firmware.Version; firmware.maybeUpgrade(startTime) {
+				log.Printf("📦 [%s] Firmware upgraded %s -> %s", deviceID, from, firmware.Version)
+			}
 
-			latencyMs := time.Since(startTime).Milliseconds()
-			success := token.Error() == nil
+			// Day/night cycle: the step ceiling and baselines follow the device's body clock
+			phase, stepsMax := 0.0, cfg.StepsPerIntervalMax
+			if cfg.Circadian {
+				phase = state.circadianPhase(startTime)
+				stepsMax = circadianStepsMax(stepsMax, phase)
+			}
 
-			// Record metrics
-			globalMetrics.RecordPublish(deviceID, latencyMs, success)
+			stepsDelta := state.updateSteps(startTime, stepsMax, rng)
+			activity := 0.0
+			if cfg.StepsPerIntervalMax > 0 {
+				activity = float64(stepsDelta) / float64(cfg.StepsPerIntervalMax)
+			}
 
-			if !success {
-				log.Printf("❌ [%s] Publish error: %v", deviceID, token.Error())
+			vitalsState := state
+			if cfg.Circadian {
+				vitalsState = state.circadianState(phase)
 			}
+
+			state.walk(cfg.GPS, activity, elapsed, rng)
+
+			// A burst flushes several readings in one tick, each with its own
+			// timestamp and sequence number; the last one before -max-messages
+			// is cut to the budget left
+			burst := min(cfg.Burst, metrics.RemainingPublishes())
+			if burst == 0 {
+				return // -max-messages budget spent
+			}
+			var batch []Telemetry
+			var batchAnomalies []bool
+			readingSpan := cfg.interval() / time.Duration(cfg.Burst)
+			for i := 0; i < burst; i++ {
+				readingStart := startTime
+				if i > 0 {
+					readingStart = time.Now()
+				}
+
+				// Occasionally simulate anomalies
+				anomaly, anomalyType := state.drawAnomaly(cfg, readingStart, rng)
+				modelAnomaly := anomaly && anomalyType == ""
+				// Falls are discrete events: flagged on a single reading only
+				fall := cfg.FallProbability > 0 && rng.Float64() < cfg.FallProbability
+
+				// Generate telemetry
+				telemetry := Telemetry{
+					TenantID:   tenantID,
+					DeviceID:   deviceID,
+					Timestamp:  cfg.FormatTime(clock.Now()),
+					Seq:        seq,
+					Metrics:    generate(cfg.Generators, vitalsState, Reading{Activity: activity, Anomaly: modelAnomaly, Time: readingStart, Interval: readingSpan, Rng: rng}),
+					BatteryPct: int(math.Ceil(state.Battery)),
+					FWVersion:  firmware.Version,
+					Padding:    padding,
+				}
+				seq++
+				if anomaly && !modelAnomaly {
+					anomalyTypes[anomalyType](&telemetry.Metrics, rng)
+				}
+				for _, r := range cfg.AnomalyTypeRates {
+					if !cfg.AnomalyExcluded && rng.Float64() < r.Rate {
+						anomalyTypes[r.Name](&telemetry.Metrics, rng)
+						anomaly = true
+					}
+				}
+				if fall {
+					fallResponse(&telemetry.Metrics, rng)
+					log.Printf("🤕 [%s] Fall detected", deviceID)
+				}
+				if cfg.Bounds != nil {
+					cfg.Bounds.clamp(&telemetry.Metrics)
+				}
+
+				// Scripted scenario events override generated values
+				if cfg.Scenario != nil {
+					cfg.Scenario.Apply(deviceID, cfg.Index, readingStart, &telemetry.Metrics)
+				}
+
+				// Publish
+				topic := cfg.Topics.Topic(tenantID, deviceID)
+				for _, recorder := range cfg.Recorders {
+					recorder.Record(topic, telemetry)
+				}
+				if cfg.BurstMode == "array" {
+					batch = append(batch, telemetry)
+					batchAnomalies = append(batchAnomalies, anomaly)
+					continue
+				}
+				topic, payload, err := encodeTelemetry(telemetry, topic, cfg, metrics)
+				if err != nil {
+					logEvent(slog.LevelError, fmt.Sprintf("❌ [%s] Failed to encode telemetry: %v", deviceID, err),
+						"encode failed", "device_id", deviceID, "tenant_id", tenantID, "error", err.Error())
+					continue
+				}
+				if !send(topic, payload, []bool{anomaly}, readingStart) {
+					return
+				}
+			}
+			if len(batch) > 0 {
+				topic, payload, err := encodeBatch(batch, cfg.Topics.Topic(tenantID, deviceID), cfg, metrics)
+				if err != nil {
+					logEvent(slog.LevelError, fmt.Sprintf("❌ [%s] Failed to encode telemetry: %v", deviceID, err),
+						"encode failed", "device_id", deviceID, "tenant_id", tenantID, "error", err.Error())
+					continue
+				}
+				if !send(topic, payload, batchAnomalies, startTime) {
+					return
+				}
+			}
+
+			// A tick that takes most of its interval leaves the device no slack,
+			// so the next one fires late and the requested rate is not reached
+			if elapsed, interval := time.Since(startTime), cfg.interval(); elapsed >= time.Duration(float64(interval)*slowTickFraction) {
+				if total, warn := metrics.RecordSlowTick(); warn {
+					logEvent(slog.LevelWarn,
+						fmt.Sprintf("🐌 [%s] Tick took %v of its %v interval; devices are falling behind the requested rate (%d slow ticks so far)",
+							deviceID, elapsed.Round(time.Millisecond), interval, total),
+						"slow tick", "device_id", deviceID, "elapsed_ms", elapsed.Milliseconds(),
+						"interval_ms", interval.Milliseconds(), "slow_ticks", total)
+				}
+			}
+		}
+	}
+}
+
+// errInjectedPublish is the failure recorded for an -inject-error-rate hit
+var errInjectedPublish = errors.New("injected failure (-inject-error-rate)")
+
+const (
+	slowTickFraction     = 0.9              // share of the interval a tick may take before it counts as slow
+	slowTickWarnInterval = 10 * time.Second // minimum gap between slow tick warnings
+)
+
+// interval returns the device's publish interval, stretched while
+// -adaptive-rate has slowed the fleet down
+func (c DeviceConfig) interval() time.Duration {
+	if c.Rate == nil {
+		return c.Interval
+	}
+	return c.Rate.interval(c.Interval)
+}
+
+// paddingAlphabet keeps padding JSON-safe, so it adds exactly its length
+const paddingAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// randomPadding returns n random alphanumeric bytes. Random filler, unlike
+// a repeated character, does not shrink away under -compress.
+func randomPadding(n int, rng *rand.Rand) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = paddingAlphabet[rng.Intn(len(paddingAlphabet))]
+	}
+	return string(b)
+}
+
+// jitteredInterval randomizes interval uniformly within ±jitter (a fraction)
+// so devices drift apart instead of publishing in lockstep
+func jitteredInterval(interval time.Duration, jitter float64, rng *rand.Rand) time.Duration {
+	if jitter == 0 {
+		return interval
+	}
+	return time.Duration(float64(interval) * (1 + jitter*(rng.Float64()*2-1)))
+}
+
+// shutdownPublishers announces every device offline in parallel, then
+// disconnects it; timeout bounds the whole step so a wedged broker can't
+// hang shutdown
+func shutdownPublishers(publishers []Publisher, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var failed atomic.Int64
+	announced := 0
+	for _, publisher := range publishers {
+		if s, ok := findPublisher[Shutdowner](publisher); ok && !s.Online() {
+			continue // already announced itself offline, e.g. on a depleted battery
 		}
+		announced++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := shutdownPublisher(ctx, publisher); err != nil {
+				failed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := failed.Load(); n > 0 {
+		log.Printf("⚠️  %d of %d devices did not confirm offline status within %v", n, announced, timeout)
+	} else if announced > 0 {
+		log.Printf("👋 Announced %d devices offline", announced)
 	}
 }
 
-// metricsReporter prints stats every 10 seconds
-func metricsReporter() {
+// metricsReporter prints stats every 10 seconds until ctx is cancelled
+func metricsReporter(ctx context.Context) {
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
 		stats := globalMetrics.GetStats()
-		log.Printf("📊 Throughput: %.0f msg/s | Published: %d | Errors: %d | Avg Latency: %dms | P95: %dms",
+		window := globalMetrics.TakeWindow()
+		text := fmt.Sprintf("📊 Throughput: %.0f msg/s (avg %.0f) | Published: %d | Errors: %d | Avg Latency: %dms | P95: %dms",
+			window.MessagesPerSec,
 			stats["messages_per_sec"],
 			stats["total_published"],
 			stats["total_errors"],
 			stats["avg_latency_ms"],
 			stats["p95_latency_ms"],
 		)
+		if scale := stats["rate_scale"].(float64); scale > 0 {
+			text += fmt.Sprintf(" | Rate: %.0f%%", scale*100)
+		}
+		logEvent(slog.LevelInfo, text, "progress",
+			"rate_scale", stats["rate_scale"],
+			"window_messages_per_sec", window.MessagesPerSec,
+			"messages_per_sec", stats["messages_per_sec"],
+			"total_published", stats["total_published"],
+			"total_errors", stats["total_errors"],
+			"avg_latency_ms", stats["avg_latency_ms"],
+			"p95_latency_ms", stats["p95_latency_ms"],
+		)
 	}
 }
\ No newline at end of file
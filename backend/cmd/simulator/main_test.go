@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// failingPublisher is a fakePublisher whose every failEvery-th Publish call
+// fails instead of being recorded
+type failingPublisher struct {
+	fakePublisher
+	failEvery int
+	attempts  int
+}
+
+var errFakePublish = errors.New("fake publish failure")
+
+func (p *failingPublisher) Publish(ctx context.Context, topic string, payload []byte) error {
+	p.mu.Lock()
+	p.attempts++
+	fail := p.attempts%p.failEvery == 0
+	p.mu.Unlock()
+
+	if fail {
+		return errFakePublish
+	}
+	return p.fakePublisher.Publish(ctx, topic, payload)
+}
+
+// testDeviceConfig builds the device configuration main would from the
+// default flags, with a fast interval
+func testDeviceConfig(t *testing.T, interval time.Duration) DeviceConfig {
+	t.Helper()
+
+	cfg := &Config{}
+	fs := flag.NewFlagSet("simulator", flag.ContinueOnError)
+	registerFlags(fs, cfg)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatalf("parse default flags: %v", err)
+	}
+	topics, err := parseTopicTemplate(defaultTopicTemplate)
+	if err != nil {
+		t.Fatalf("parse topic template: %v", err)
+	}
+
+	return DeviceConfig{
+		Interval:            interval,
+		BatteryDrainPerHour: cfg.BatteryDrainPerHour,
+		StepsPerIntervalMax: cfg.StepsPerIntervalMax,
+		Generators:          buildGenerators(cfg),
+		PublishTimeout:      cfg.PublishTimeout,
+		Burst:               cfg.Burst,
+		BurstMode:           cfg.BurstMode,
+		OnDisconnect:        cfg.OnDisconnect,
+		DisconnectBuffer:    cfg.DisconnectBuffer,
+		Topics:              topics,
+		Encoding:            encodings[cfg.Encoding],
+		FormatTime:          timestampFormats[cfg.TimestampFormat],
+		GPS:                 GPSModel{HomeLat: cfg.HomeLat, HomeLon: cfg.HomeLon, RadiusM: cfg.GPSRadiusM},
+	}
+}
+
+// TestPublishTelemetry runs one device on a fast interval against a fake
+// that fails every third publish, until a -max-messages budget stops it
+func TestPublishTelemetry(t *testing.T) {
+	const maxMessages, failEvery = 12, 3
+	fake := &failingPublisher{failEvery: failEvery}
+	metrics := newTracker(time.Now(), MetricsOptions{LatencySampleSize: 100, MaxMessages: maxMessages})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	publishTelemetry(ctx, &wg, fake, metrics, "acme", "watch-0000", testDeviceConfig(t, 5*time.Millisecond), rand.New(rand.NewSource(1)))
+	if ctx.Err() != nil {
+		t.Fatalf("device did not stop at the -max-messages budget")
+	}
+
+	if fake.attempts != maxMessages {
+		t.Errorf("publish attempts = %d, want %d", fake.attempts, maxMessages)
+	}
+	wantErrors := int64(maxMessages / failEvery)
+	stats := metrics.GetStats()
+	if got := stats["total_published"].(int64); got != int64(fake.calls) || got != maxMessages-wantErrors {
+		t.Errorf("total_published = %d, want %d (delivered %d)", got, maxMessages-wantErrors, fake.calls)
+	}
+	if got := stats["total_errors"].(int64); got != wantErrors {
+		t.Errorf("total_errors = %d, want %d", got, wantErrors)
+	}
+	for _, topic := range fake.topics {
+		if topic != "tenants/acme/devices/watch-0000/telemetry" {
+			t.Errorf("topic = %q, want tenants/acme/devices/watch-0000/telemetry", topic)
+		}
+	}
+	for i, payload := range fake.payloads {
+		if !strings.Contains(string(payload), `"device_id":"watch-0000"`) {
+			t.Errorf("payload %d lacks the device ID: %s", i, payload)
+		}
+	}
+}
+
+// TestConcurrentDevices runs a fleet of devices at once, each drawing from
+// its own deviceSeed rand as main starts them, into one shared tracker. Run
+// it with -race to catch devices sharing random state again.
+func TestConcurrentDevices(t *testing.T) {
+	const devices, perDevice = 50, 5
+	metrics := newTracker(time.Now(), MetricsOptions{LatencySampleSize: 100, MaxMessages: devices * perDevice})
+	cfg := testDeviceConfig(t, 2*time.Millisecond)
+	cfg.Jitter = 0.5
+	cfg.InjectErrorRate = 0.2
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < devices; i++ {
+		wg.Add(1)
+		deviceCfg := cfg
+		deviceCfg.Index = i
+		rng := rand.New(rand.NewSource(deviceSeed(1, i)))
+		go publishTelemetry(ctx, &wg, &fakePublisher{}, metrics, "acme", fmt.Sprintf("watch-%04d", i), deviceCfg, rng)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		t.Fatalf("devices did not stop at the -max-messages budget")
+	}
+
+	stats := metrics.GetStats()
+	published, failed := stats["total_published"].(int64), stats["total_errors"].(int64)
+	if published+failed != devices*perDevice {
+		t.Errorf("published %d + errors %d, want %d in total", published, failed, devices*perDevice)
+	}
+	if injected := stats["injected_errors"].(int64); injected != failed {
+		t.Errorf("injected_errors = %d, want every error (%d)", injected, failed)
+	}
+}
+
+// TestBurstStopsAtBudget checks that bursts reaching a -max-messages budget
+// that is not a multiple of the burst publish exactly the budget, cutting
+// the last burst short, and that the limit then fires
+func TestBurstStopsAtBudget(t *testing.T) {
+	const maxMessages, burst = 10, 3
+	for _, tt := range []struct {
+		mode      string
+		wantCalls int
+	}{
+		{"separate", maxMessages},
+		{"array", maxMessages/burst + 1},
+	} {
+		t.Run(tt.mode, func(t *testing.T) {
+			fake := &fakePublisher{}
+			metrics := newTracker(time.Now(), MetricsOptions{LatencySampleSize: 100, MaxMessages: maxMessages})
+			cfg := testDeviceConfig(t, 5*time.Millisecond)
+			cfg.Burst, cfg.BurstMode = burst, tt.mode
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			var wg sync.WaitGroup
+			wg.Add(1)
+			publishTelemetry(ctx, &wg, fake, metrics, "acme", "watch-0000", cfg, rand.New(rand.NewSource(1)))
+			if ctx.Err() != nil {
+				t.Fatalf("device did not stop at the -max-messages budget")
+			}
+
+			select {
+			case <-metrics.LimitReached():
+			default:
+				t.Errorf("LimitReached not closed after the budget was spent")
+			}
+			if fake.calls != tt.wantCalls {
+				t.Errorf("publishes = %d, want %d", fake.calls, tt.wantCalls)
+			}
+			if got := metrics.GetStats()["total_published"].(int64); got != maxMessages {
+				t.Errorf("total_published = %d, want %d", got, maxMessages)
+			}
+		})
+	}
+}
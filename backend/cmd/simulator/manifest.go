@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// runManifest records how a run was produced (-manifest), so a results
+// folder with the metrics CSV and JSON describes itself
+type runManifest struct {
+	StartedAt string                 `json:"started_at"`
+	Seed      int64                  `json:"seed"`
+	Hostname  string                 `json:"hostname"`
+	Brokers   []string               `json:"brokers"` // credentials redacted
+	Build     buildInfo              `json:"build"`
+	Flags     map[string]string      `json:"flags"`
+	Config    map[string]interface{} `json:"config"` // every resolved setting, including config-file only ones
+}
+
+// redactedBrokers returns the broker URLs with any password in their user info hidden
+func redactedBrokers(cfg *Config) []string {
+	var brokers []string
+	for _, broker := range cfg.Brokers() {
+		if u, err := url.Parse(broker); err == nil {
+			broker = u.Redacted()
+		}
+		brokers = append(brokers, broker)
+	}
+	return brokers
+}
+
+// redactedConfig returns every Config field by its YAML key, secrets redacted
+func redactedConfig(cfg *Config) map[string]interface{} {
+	values := make(map[string]interface{})
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		value := v.Field(i).Interface()
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+		values[configKey(v.Type().Field(i))] = value
+	}
+
+	if cfg.Password != "" {
+		values["password"] = "REDACTED"
+	}
+	values["broker"] = strings.Join(redactedBrokers(cfg), ",")
+	return values
+}
+
+// writeManifest writes the run manifest for cfg to path
+func writeManifest(path string, cfg *Config, start time.Time) error {
+	hostname, _ := os.Hostname()
+
+	manifest := runManifest{
+		StartedAt: start.UTC().Format(time.RFC3339),
+		Seed:      cfg.Seed,
+		Hostname:  hostname,
+		Brokers:   redactedBrokers(cfg),
+		Build:     readBuildInfo(),
+		Flags:     resolvedFlags(),
+		Config:    redactedConfig(cfg),
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// deviceModel is the hardware model simulated devices register as
+const deviceModel = "HealthSense Watch (simulated)"
+
+// DeviceMeta is the retained registration message a device publishes to its
+// meta topic once at startup (-publish-metadata), for device-registry ingestion
+type DeviceMeta struct {
+	TenantID     string   `json:"tenant_id"`
+	DeviceID     string   `json:"device_id"`
+	Model        string   `json:"model"`
+	FWVersion    string   `json:"fw_version"`
+	Profile      string   `json:"profile,omitempty"`
+	Capabilities []string `json:"capabilities"` // metrics fields the device reports
+	IntervalMs   int64    `json:"interval_ms"`
+	ContentType  string   `json:"content_type"` // telemetry payload encoding
+	StartedAt    string   `json:"started_at"`
+}
+
+// publishMetadata publishes the device's DeviceMeta, retained where the
+// transport supports it so a registry that subscribes later still sees it
+func publishMetadata(ctx context.Context, publisher Publisher, tenantID, deviceID string, cfg DeviceConfig) {
+	meta := DeviceMeta{
+		TenantID:     tenantID,
+		DeviceID:     deviceID,
+		Model:        deviceModel,
+		FWVersion:    cfg.Firmware.Version,
+		Profile:      cfg.ProfileName,
+		Capabilities: cfg.Capabilities,
+		IntervalMs:   cfg.interval().Milliseconds(),
+		ContentType:  cfg.Encoding.contentType,
+		StartedAt:    time.Now().UTC().Format(time.RFC3339),
+	}
+	payload, _ := json.Marshal(meta)
+
+	var err error
+	if r, ok := findPublisher[RetainedPublisher](publisher); ok {
+		err = r.PublishRetained(ctx, metaTopic(tenantID, deviceID), payload)
+	} else {
+		err = publisher.Publish(ctx, metaTopic(tenantID, deviceID), payload)
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Printf("❌ [%s] Metadata publish error: %v", deviceID, err)
+	}
+}
@@ -1,147 +1,890 @@
 package main
 
 import (
-	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
 	"os"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // MetricsTracker tracks simulator performance
 type MetricsTracker struct {
-	mu                sync.RWMutex
-	publishCount      int64
-	publishErrors     int64
-	totalLatencyMs    int64
-	startTime         time.Time
-	latencies         []int64
-	csvWriter         *csv.Writer
-	csvFile           *os.File
-}
-
-// NewMetrics creates a new metrics tracker
-func NewMetrics(outputFile string) (*MetricsTracker, error) {
+	mu                   sync.RWMutex
+	startTime            time.Time
+	warmupEnd            time.Time // publishes before this are kept out of the steady-state stats
+	windowStart          time.Time
+	qos                  byte
+	runConfig            map[string]string
+	prom                 atomic.Pointer[promCollectors]
+	latencyBounds        []int64         // histogram bucket boundaries in ms
+	shards               []*metricsShard // per-publish counters, split by device
+	latencies            *latencyReservoir
+	sampleSize           int // -verify end-to-end reservoir size
+	sampleRand           *rand.Rand
+	e2eLatencies         []int64 // reservoir sample of end-to-end latencies (-verify)
+	e2eCount             int64
+	e2eClamped           int64 // negative latencies raised to zero
+	verifiedExpected     int64 // publishes the -verify subscriber waited for
+	droppedMessages      int64 // of which never delivered
+	outOfOrder           int64 // deliveries behind a later sequence number
+	duplicates           int64 // repeated deliveries of a sequence number
+	churnDisconnects     int64
+	disconnectBuffered   int64 // readings held back during a connection loss (-on-disconnect buffer)
+	disconnectDropped    int64 // readings skipped during one, or buffered past -disconnect-buffer
+	heartbeats           int64 // idle status publishes outside a device's schedule
+	heartbeatErrors      int64
+	slowTicks            int64     // ticks whose publish took most of the interval
+	injectedErrors       int64     // -inject-error-rate failures, included in publishErrors
+	lastSlowTickWarn     time.Time // throttles the slow tick warning
+	churnReconnectErrors int64
+	connectionsLost      int64 // unexpected broker disconnects, handled by auto-reconnect
+	reconnectAttempts    int64
+	reconnectCount       int64
+	downtime             time.Duration // summed over completed reconnects
+	inflightBlocks       int64         // publishes that waited for a -max-inflight slot
+	inflightWait         time.Duration
+	uncompressedBytes    int64 // JSON size of compressed payloads (-compress)
+	compressedBytes      int64
+	csv                  *csvSink
+	maxMessages          int64                  // -max-messages; 0 = unlimited
+	reserved             atomic.Int64           // publishes handed out by ReservePublishes
+	recorded             atomic.Int64           // publishes recorded, including warmup and failures
+	limitHit             chan struct{}          // closed once maxMessages publishes are recorded
+	brokers              map[string]*deviceStat // per-broker publishes when fanning out
+	rateScale            float64                // current -adaptive-rate fraction; 0 = off
+	rateAdjustments      int64
+	minRateScale         float64
+}
+
+// deviceStat holds per-device publish counters
+type deviceStat struct {
+	publishCount   int64
+	publishErrors  int64
+	totalLatencyMs int64
+}
+
+// BrokerStats is a per-broker summary returned by GetBrokerStats
+type BrokerStats struct {
+	Broker       string `json:"broker"`
+	Published    int64  `json:"published"`
+	Errors       int64  `json:"errors"`
+	AvgLatencyMs int64  `json:"avg_latency_ms"`
+}
+
+// DeviceStats is a per-device summary returned by GetPerDeviceStats
+type DeviceStats struct {
+	DeviceID     string
+	Published    int64
+	Errors       int64
+	AvgLatencyMs int64
+}
+
+// MetricsOptions configures a MetricsTracker
+type MetricsOptions struct {
+	QoS byte
+	// LatencySampleSize bounds the latencies kept for percentiles. Once more
+	// publishes than this have been recorded, reservoir sampling keeps a
+	// uniform random subset, so percentiles become approximate.
+	LatencySampleSize int
+	Seed              int64
+	// Warmup is the initial period whose publishes are reported separately
+	// instead of skewing the steady-state numbers
+	Warmup time.Duration
+	// MaxMessages caps the publishes handed out by ReservePublishes (0 = unlimited)
+	MaxMessages int64
+	// LatencyBuckets are the histogram boundaries in ms, see parseLatencyBuckets
+	LatencyBuckets []int64
+	// Shards splits the per-publish counters by device so concurrent
+	// publishes rarely share a lock (0 = one shard)
+	Shards int
+	// CSVFlushInterval bounds how long CSV rows stay buffered in memory, and
+	// so how many a crash can lose (0 = flush every csvFlushEvery rows only)
+	CSVFlushInterval time.Duration
+}
+
+// NewMetrics creates a new metrics tracker writing every publish to the
+// CSV at outputFile; an empty outputFile keeps only in-memory stats
+func NewMetrics(outputFile string, opts MetricsOptions) (*MetricsTracker, error) {
+	if outputFile == "" {
+		return newTracker(time.Now(), opts), nil
+	}
 	file, err := os.Create(outputFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create metrics file: %w", err)
 	}
 
-	writer := csv.NewWriter(file)
-	// Write CSV header
-	writer.Write([]string{"timestamp", "device_id", "publish_latency_ms", "success"})
-	writer.Flush()
+	m := newTracker(time.Now(), opts)
+	m.csv = newCSVSink(file, opts.CSVFlushInterval)
+	return m, nil
+}
 
+// newTracker creates a tracker for a run starting at start, without a CSV;
+// offline analysis replays recorded publishes into one
+func newTracker(start time.Time, opts MetricsOptions) *MetricsTracker {
 	return &MetricsTracker{
-		startTime: time.Now(),
-		csvWriter: writer,
-		csvFile:   file,
-		latencies: make([]int64, 0, 10000),
-	}, nil
+		startTime:     start,
+		warmupEnd:     start.Add(opts.Warmup),
+		windowStart:   start,
+		qos:           opts.QoS,
+		shards:        newMetricsShards(opts.Shards, opts),
+		latencies:     newLatencyReservoir(opts.LatencySampleSize, opts.Seed),
+		sampleSize:    opts.LatencySampleSize,
+		sampleRand:    rand.New(rand.NewSource(opts.Seed)),
+		maxMessages:   opts.MaxMessages,
+		latencyBounds: opts.LatencyBuckets,
+		limitHit:      make(chan struct{}),
+	}
+}
+
+// promCollectors holds the Prometheus collectors updated on every publish
+type promCollectors struct {
+	published prometheus.Counter
+	errors    prometheus.Counter
+	latency   prometheus.Histogram
+}
+
+// RegisterPrometheus registers simulator collectors with reg and starts
+// updating them from RecordPublish
+func (m *MetricsTracker) RegisterPrometheus(reg prometheus.Registerer) error {
+	collectors := &promCollectors{
+		published: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "simulator_published_total",
+			Help: "Total telemetry messages published successfully",
+		}),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "simulator_publish_errors_total",
+			Help: "Total telemetry publish failures",
+		}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "simulator_publish_latency_ms",
+			Help:    "Publish latency in milliseconds",
+			Buckets: []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000},
+		}),
+	}
+	throughput := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "simulator_messages_per_sec",
+		Help: "Average publish throughput since start",
+	}, func() float64 {
+		totals, _, _ := m.shardTotals()
+		return float64(totals.publishCount) / time.Since(m.startTime).Seconds()
+	})
+
+	for _, c := range []prometheus.Collector{collectors.published, collectors.errors, collectors.latency, throughput} {
+		if err := reg.Register(c); err != nil {
+			return fmt.Errorf("failed to register prometheus collector: %w", err)
+		}
+	}
+
+	m.prom.Store(collectors)
+	return nil
+}
+
+// RecordPublish records a publish event of a payload of the given size in
+// bytes; anomaly marks messages that carried an injected anomaly
+func (m *MetricsTracker) RecordPublish(tenantID, deviceID string, latencyMs int64, bytes int, success, anomaly bool) {
+	now := time.Now()
+	warmup := now.Before(m.warmupEnd)
+	m.csv.Write(csvRecord{
+		timestamp: now,
+		tenantID:  tenantID,
+		deviceID:  deviceID,
+		latencyMs: latencyMs,
+		bytes:     bytes,
+		success:   success,
+		warmup:    warmup,
+		anomaly:   anomaly,
+	})
+
+	m.record(deviceID, latencyMs, bytes, success, warmup)
+}
+
+// record updates the counters for one publish; only the device's shard is locked
+func (m *MetricsTracker) record(deviceID string, latencyMs int64, bytes int, success, warmup bool) {
+	if prom := m.prom.Load(); prom != nil {
+		if success {
+			prom.published.Inc()
+			prom.latency.Observe(float64(latencyMs))
+		} else {
+			prom.errors.Inc()
+		}
+	}
+
+	m.countRecorded(1)
+
+	m.shard(deviceID).record(deviceID, latencyMs, bytes, success, warmup, m.latencyBounds)
+	if success && !warmup {
+		m.latencies.add(latencyMs)
+	}
 }
 
-// RecordPublish records a publish event
-func (m *MetricsTracker) RecordPublish(deviceID string, latencyMs int64, success bool) {
+// RecordBrokerPublish records the outcome of one broker's share of a fanned-out publish
+func (m *MetricsTracker) RecordBrokerPublish(broker string, latencyMs int64, success bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if m.brokers == nil {
+		m.brokers = make(map[string]*deviceStat)
+	}
+	stat, ok := m.brokers[broker]
+	if !ok {
+		stat = &deviceStat{}
+		m.brokers[broker] = stat
+	}
 	if success {
-		m.publishCount++
-		m.totalLatencyMs += latencyMs
-		m.latencies = append(m.latencies, latencyMs)
+		stat.publishCount++
+		stat.totalLatencyMs += latencyMs
 	} else {
-		m.publishErrors++
+		stat.publishErrors++
 	}
+}
+
+// RecordE2ELatency records the end-to-end latency of one delivered message;
+// clamped marks a negative latency that was raised to zero
+func (m *MetricsTracker) RecordE2ELatency(latencyMs int64, clamped bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	// Write to CSV
-	successStr := "1"
-	if !success {
-		successStr = "0"
+	m.e2eCount++
+	if clamped {
+		m.e2eClamped++
 	}
-	m.csvWriter.Write([]string{
-		time.Now().Format(time.RFC3339),
-		deviceID,
-		fmt.Sprintf("%d", latencyMs),
-		successStr,
-	})
+
+	if len(m.e2eLatencies) < m.sampleSize {
+		m.e2eLatencies = append(m.e2eLatencies, latencyMs)
+	} else if j := m.sampleRand.Int63n(m.e2eCount); j < int64(m.sampleSize) {
+		m.e2eLatencies[j] = latencyMs
+	}
+}
+
+// RecordVerification records the outcome of delivery verification
+func (m *MetricsTracker) RecordVerification(stats VerifyStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.verifiedExpected = stats.Expected
+	m.droppedMessages = stats.Missing
+	m.outOfOrder = stats.OutOfOrder
+	m.duplicates = stats.Duplicates
+}
+
+// RecordCompression records the size of a payload before and after compression
+func (m *MetricsTracker) RecordCompression(uncompressed, compressed int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.uncompressedBytes += int64(uncompressed)
+	m.compressedBytes += int64(compressed)
+}
+
+// RecordHeartbeat records an idle device's heartbeat publish
+func (m *MetricsTracker) RecordHeartbeat(success bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if success {
+		m.heartbeats++
+	} else {
+		m.heartbeatErrors++
+	}
+}
+
+// RecordInjectedError records a publish failed on purpose by -inject-error-rate
+func (m *MetricsTracker) RecordInjectedError() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.injectedErrors++
+}
+
+// RecordSlowTick records a tick that used up most of its interval and
+// reports whether to warn about it, at most once per slowTickWarnInterval
+func (m *MetricsTracker) RecordSlowTick() (total int64, warn bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.slowTicks++
+	now := time.Now()
+	if now.Sub(m.lastSlowTickWarn) < slowTickWarnInterval {
+		return m.slowTicks, false
+	}
+	m.lastSlowTickWarn = now
+	return m.slowTicks, true
+}
+
+// RecordChurnDisconnect records a device dropping offline for churn
+func (m *MetricsTracker) RecordChurnDisconnect() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.churnDisconnects++
+}
+
+// RecordDisconnectBuffered records readings buffered while a device's connection was down
+func (m *MetricsTracker) RecordDisconnectBuffered(readings int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.disconnectBuffered += int64(readings)
+}
+
+// RecordDisconnectDropped records readings never published because a device's connection was down
+func (m *MetricsTracker) RecordDisconnectDropped(readings int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.disconnectDropped += int64(readings)
+}
+
+// RecordChurnReconnectError records a failed reconnect after churn
+func (m *MetricsTracker) RecordChurnReconnectError() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.churnReconnectErrors++
+}
+
+// RecordConnectionLost records a client losing its broker connection unexpectedly
+func (m *MetricsTracker) RecordConnectionLost() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.connectionsLost++
+}
+
+// RecordReconnectAttempt records an automatic reconnect attempt
+func (m *MetricsTracker) RecordReconnectAttempt() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reconnectAttempts++
+}
+
+// RecordReconnect records a client reconnecting after being offline for downtime
+func (m *MetricsTracker) RecordReconnect(downtime time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reconnectCount++
+	m.downtime += downtime
+}
+
+// GetLatencyHistogram returns the count of successful steady-state
+// publishes in each -latency-buckets range
+func (m *MetricsTracker) GetLatencyHistogram() []LatencyBucket {
+	_, latencyCounts, _ := m.shardTotals()
+	return histogram(m.latencyBounds, latencyCounts)
+}
+
+// ReservePublishes claims n publishes from the -max-messages budget in one
+// step and reports whether the caller may make them. Devices reserve before
+// publishing, so a fleet crossing the limit together never exceeds it. When
+// fewer than n are left, the rest of the budget is forfeited so that
+// LimitReached still closes.
+func (m *MetricsTracker) ReservePublishes(n int) bool {
+	if m.maxMessages == 0 {
+		return true
+	}
+	for {
+		reserved := m.reserved.Load()
+		left := m.maxMessages - reserved
+		if int64(n) <= left {
+			if m.reserved.CompareAndSwap(reserved, reserved+int64(n)) {
+				return true
+			}
+			continue
+		}
+		if left <= 0 {
+			return false
+		}
+		if m.reserved.CompareAndSwap(reserved, m.maxMessages) {
+			m.countRecorded(left)
+			return false
+		}
+	}
+}
+
+// RemainingPublishes returns how many publishes the -max-messages budget
+// has left to reserve; without a limit it never runs out
+func (m *MetricsTracker) RemainingPublishes() int {
+	if m.maxMessages == 0 {
+		return math.MaxInt
+	}
+	return int(max(m.maxMessages-m.reserved.Load(), 0))
+}
+
+// ForfeitPublishes gives up n reserved publishes the caller will not make.
+// They count as recorded, so LimitReached still closes once the rest are.
+func (m *MetricsTracker) ForfeitPublishes(n int) {
+	m.countRecorded(int64(n))
+}
+
+// countRecorded adds n publishes to the recorded count, closing limitHit
+// once it reaches -max-messages
+func (m *MetricsTracker) countRecorded(n int64) {
+	recorded := m.recorded.Add(n)
+	if m.maxMessages > 0 && recorded >= m.maxMessages && recorded-n < m.maxMessages {
+		close(m.limitHit)
+	}
+}
+
+// LimitReached is closed once -max-messages publishes have been recorded;
+// it never closes without a limit
+func (m *MetricsTracker) LimitReached() <-chan struct{} {
+	return m.limitHit
+}
+
+// DisconnectedClients returns how many clients have lost their connection
+// and not yet reconnected
+func (m *MetricsTracker) DisconnectedClients() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.connectionsLost - m.reconnectCount
+}
+
+// RecordInflightBlock records a publish that waited for an in-flight slot
+func (m *MetricsTracker) RecordInflightBlock(wait time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inflightBlocks++
+	m.inflightWait += wait
+}
+
+// TrackRecentLatencies starts keeping the latest size publish latencies,
+// split across the shards, for TakeRecentP95
+func (m *MetricsTracker) TrackRecentLatencies(size int) {
+	perShard := (size + len(m.shards) - 1) / len(m.shards)
+	for _, s := range m.shards {
+		s.mu.Lock()
+		s.recent = make([]int64, perShard)
+		s.mu.Unlock()
+	}
+}
+
+// TakeRecentP95 returns the P95 of the successful publish latencies
+// recorded since the previous call, or of the latest ones if more were
+// recorded than TrackRecentLatencies keeps, and how many it covers
+func (m *MetricsTracker) TakeRecentP95() (p95Ms int64, samples int) {
+	var recent []int64
+	for _, s := range m.shards {
+		s.mu.Lock()
+		for i := 0; i < s.recentCount; i++ {
+			recent = append(recent, s.recent[(s.recentNext-s.recentCount+i+len(s.recent))%len(s.recent)])
+		}
+		s.recentCount = 0
+		s.mu.Unlock()
+	}
+
+	samples = len(recent)
+	if samples == 0 {
+		return 0, 0
+	}
+	slices.Sort(recent)
+	return percentile(recent, 95), samples
+}
+
+// RecordRateScale records a change of the -adaptive-rate publish rate
+func (m *MetricsTracker) RecordRateScale(scale float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.rateScale != 0 {
+		m.rateAdjustments++
+	}
+	if m.minRateScale == 0 || scale < m.minRateScale {
+		m.minRateScale = scale
+	}
+	m.rateScale = scale
+}
+
+// WindowStats summarizes publishes in one reporting window
+type WindowStats struct {
+	Published      int64
+	Errors         int64
+	Duration       time.Duration
+	MessagesPerSec float64
+}
+
+// TakeWindow returns stats since the previous call and starts a new window
+func (m *MetricsTracker) TakeWindow() WindowStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	window := WindowStats{Duration: now.Sub(m.windowStart)}
+	for _, s := range m.shards {
+		s.mu.Lock()
+		window.Published += s.windowPublished
+		window.Errors += s.windowErrors
+		s.windowPublished = 0
+		s.windowErrors = 0
+		s.mu.Unlock()
+	}
+	if window.Duration > 0 {
+		window.MessagesPerSec = float64(window.Published) / window.Duration.Seconds()
+	}
+
+	m.windowStart = now
+
+	return window
 }
 
 // GetStats returns current statistics
 func (m *MetricsTracker) GetStats() map[string]interface{} {
+	return m.statsAt(time.Now())
+}
+
+// statsAt returns statistics with elapsed time and rates measured up to now
+func (m *MetricsTracker) statsAt(now time.Time) map[string]interface{} {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	elapsed := time.Since(m.startTime).Seconds()
+	t, latencyCounts, samples := m.shardTotals()
+
+	elapsed := now.Sub(m.startTime).Seconds()
 	avgLatency := int64(0)
-	if m.publishCount > 0 {
-		avgLatency = m.totalLatencyMs / m.publishCount
+	if t.publishCount > 0 {
+		avgLatency = t.totalLatencyMs / t.publishCount
+	}
+
+	// Rates cover the steady state only, i.e. the time since warmup ended
+	messagesPerSec, bytesPerSec := 0.0, 0.0
+	if steady := now.Sub(m.warmupEnd).Seconds(); steady > 0 {
+		messagesPerSec = float64(t.publishCount) / steady
+		bytesPerSec = float64(t.totalBytes) / steady
+	}
+	warmupAvgLatency := int64(0)
+	if t.warmupPublished > 0 {
+		warmupAvgLatency = t.warmupLatencyMs / t.warmupPublished
 	}
 
 	p50, p95, p99 := m.calculatePercentiles()
+	minLatency, maxLatency, stddev := t.latencySpread()
+	e2eP50, e2eP95, e2eP99 := m.e2ePercentiles()
+	avgBytes := int64(0)
+	if t.publishCount > 0 {
+		avgBytes = t.totalBytes / t.publishCount
+	}
+	dropRate := 0.0
+	if m.verifiedExpected > 0 {
+		dropRate = float64(m.droppedMessages) / float64(m.verifiedExpected) * 100
+	}
+	compressionRatio := 0.0
+	if m.uncompressedBytes > 0 {
+		compressionRatio = float64(m.compressedBytes) / float64(m.uncompressedBytes)
+	}
 
 	return map[string]interface{}{
-		"total_published":  m.publishCount,
-		"total_errors":     m.publishErrors,
-		"messages_per_sec": float64(m.publishCount) / elapsed,
-		"avg_latency_ms":   avgLatency,
-		"p50_latency_ms":   p50,
-		"p95_latency_ms":   p95,
-		"p99_latency_ms":   p99,
-		"elapsed_sec":      elapsed,
+		"total_published":        t.publishCount,
+		"total_errors":           t.publishErrors,
+		"messages_per_sec":       messagesPerSec,
+		"total_bytes":            t.totalBytes,
+		"bytes_per_sec":          bytesPerSec,
+		"avg_message_bytes":      avgBytes,
+		"avg_latency_ms":         avgLatency,
+		"p50_latency_ms":         p50,
+		"p95_latency_ms":         p95,
+		"p99_latency_ms":         p99,
+		"min_latency_ms":         minLatency,
+		"max_latency_ms":         maxLatency,
+		"stddev_latency_ms":      stddev,
+		"e2e_p50_latency_ms":     e2eP50,
+		"e2e_p95_latency_ms":     e2eP95,
+		"e2e_p99_latency_ms":     e2eP99,
+		"e2e_samples":            m.e2eCount,
+		"e2e_clamped":            m.e2eClamped,
+		"verified_messages":      m.verifiedExpected,
+		"dropped_messages":       m.droppedMessages,
+		"drop_rate":              dropRate,
+		"out_of_order_count":     m.outOfOrder,
+		"duplicate_count":        m.duplicates,
+		"churn_disconnects":      m.churnDisconnects,
+		"disconnect_buffered":    m.disconnectBuffered,
+		"disconnect_dropped":     m.disconnectDropped,
+		"heartbeats":             m.heartbeats,
+		"heartbeat_errors":       m.heartbeatErrors,
+		"slow_ticks":             m.slowTicks,
+		"injected_errors":        m.injectedErrors,
+		"churn_reconnect_errors": m.churnReconnectErrors,
+		"connections_lost":       m.connectionsLost,
+		"reconnect_attempts":     m.reconnectAttempts,
+		"reconnect_count":        m.reconnectCount,
+		"downtime_sec":           m.downtime.Seconds(),
+		"inflight_blocks":        m.inflightBlocks,
+		"inflight_wait_sec":      m.inflightWait.Seconds(),
+		"uncompressed_bytes":     m.uncompressedBytes,
+		"compressed_bytes":       m.compressedBytes,
+		"warmup_sec":             m.warmupEnd.Sub(m.startTime).Seconds(),
+		"warmup_published":       t.warmupPublished,
+		"warmup_errors":          t.warmupErrors,
+		"warmup_avg_latency_ms":  warmupAvgLatency,
+		"compression_ratio":      compressionRatio,
+		"metrics_write_errors":   m.csv.Errors(),
+		"latency_samples":        samples,
+		"latency_histogram":      histogram(m.latencyBounds, latencyCounts),
+		"brokers":                m.brokerStats(),
+		"rate_scale":             m.rateScale,
+		"min_rate_scale":         m.minRateScale,
+		"rate_adjustments":       m.rateAdjustments,
+		"latency_sampled":        t.publishCount > int64(samples),
+		"elapsed_sec":            elapsed,
+		"qos":                    m.qos,
 	}
 }
 
-// calculatePercentiles calculates latency percentiles
-func (m *MetricsTracker) calculatePercentiles() (p50, p95, p99 int64) {
-	if len(m.latencies) == 0 {
-		return 0, 0, 0
+// GetBrokerStats returns per-broker stats of a fanned-out run, sorted by broker
+func (m *MetricsTracker) GetBrokerStats() []BrokerStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.brokerStats()
+}
+
+// brokerStats builds the per-broker summaries. Caller must hold at least m.mu.RLock.
+func (m *MetricsTracker) brokerStats() []BrokerStats {
+	result := make([]BrokerStats, 0, len(m.brokers))
+	for broker, stat := range m.brokers {
+		avg := int64(0)
+		if stat.publishCount > 0 {
+			avg = stat.totalLatencyMs / stat.publishCount
+		}
+		result = append(result, BrokerStats{
+			Broker:       broker,
+			Published:    stat.publishCount,
+			Errors:       stat.publishErrors,
+			AvgLatencyMs: avg,
+		})
 	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Broker < result[j].Broker })
+	return result
+}
 
-	// Simple percentile calculation (not sorted, approximate)
-	sorted := make([]int64, len(m.latencies))
-	copy(sorted, m.latencies)
-	
-	// Bubble sort (good enough for small datasets)
-	for i := 0; i < len(sorted); i++ {
-		for j := i + 1; j < len(sorted); j++ {
-			if sorted[i] > sorted[j] {
-				sorted[i], sorted[j] = sorted[j], sorted[i]
+// GetPerDeviceStats returns per-device stats, slowest average latency first
+func (m *MetricsTracker) GetPerDeviceStats() []DeviceStats {
+	var result []DeviceStats
+	for _, s := range m.shards {
+		s.mu.Lock()
+		for id, d := range s.devices {
+			avgLatency := int64(0)
+			if d.publishCount > 0 {
+				avgLatency = d.totalLatencyMs / d.publishCount
 			}
+			result = append(result, DeviceStats{
+				DeviceID:     id,
+				Published:    d.publishCount,
+				Errors:       d.publishErrors,
+				AvgLatencyMs: avgLatency,
+			})
 		}
+		s.mu.Unlock()
 	}
 
-	p50 = sorted[len(sorted)*50/100]
-	p95 = sorted[len(sorted)*95/100]
-	p99 = sorted[len(sorted)*99/100]
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].AvgLatencyMs != result[j].AvgLatencyMs {
+			return result[i].AvgLatencyMs > result[j].AvgLatencyMs
+		}
+		return result[i].DeviceID < result[j].DeviceID
+	})
+
+	return result
+}
+
+// calculatePercentiles calculates latency percentiles over the latency sample
+func (m *MetricsTracker) calculatePercentiles() (p50, p95, p99 int64) {
+	sorted := m.latencies.sortedSnapshot()
+	if len(sorted) == 0 {
+		return 0, 0, 0
+	}
+
+	p50 = percentile(sorted, 50)
+	p95 = percentile(sorted, 95)
+	p99 = percentile(sorted, 99)
 
 	return
 }
 
-// Flush writes any buffered data and closes the file
+// e2ePercentiles calculates end-to-end latency percentiles. Caller must hold at least m.mu.RLock.
+func (m *MetricsTracker) e2ePercentiles() (p50, p95, p99 int64) {
+	if len(m.e2eLatencies) == 0 {
+		return 0, 0, 0
+	}
+
+	sorted := slices.Clone(m.e2eLatencies)
+	slices.Sort(sorted)
+
+	return percentile(sorted, 50), percentile(sorted, 95), percentile(sorted, 99)
+}
+
+// percentile returns the value at pct from an ascending sorted slice,
+// clamping the index so it never runs past the last element
+func percentile(sorted []int64, pct int) int64 {
+	idx := len(sorted) * pct / 100
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+// Flush writes any queued CSV rows and closes the file. No publishes may be
+// recorded after Flush.
 func (m *MetricsTracker) Flush() {
+	m.csv.Close()
+}
+
+// SetRunConfig records the resolved run configuration for inclusion in exports
+func (m *MetricsTracker) SetRunConfig(config map[string]string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.csvWriter.Flush()
-	m.csvFile.Close()
+	m.runConfig = config
+}
+
+// WriteJSON writes the aggregated statistics, run timestamp and configuration to path
+func (m *MetricsTracker) WriteJSON(path string) error {
+	stats := m.GetStats()
+
+	m.mu.RLock()
+	report := map[string]interface{}{
+		"generated_at": time.Now().UTC().Format(time.RFC3339),
+		"started_at":   m.startTime.UTC().Format(time.RFC3339),
+		"config":       m.runConfig,
+		"stats":        stats,
+	}
+	m.mu.RUnlock()
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write metrics JSON: %w", err)
+	}
+
+	return nil
 }
 
 // PrintStats prints current statistics to console
 func (m *MetricsTracker) PrintStats() {
-	stats := m.GetStats()
+	printStats(m.GetStats())
+}
+
+// printStats prints a GetStats map as the final metrics table. Keys absent
+// from the map, such as qos for offline analysis, are left out.
+func printStats(stats map[string]interface{}) {
+	if jsonLogs {
+		logStats("final stats", stats)
+		return
+	}
+	separator := strings.Repeat("=", 60)
+
+	fmt.Fprintln(reportOut, "\n"+separator)
+	fmt.Fprintln(reportOut, "SIMULATOR METRICS")
+	fmt.Fprintln(reportOut, separator)
+	if qos, ok := stats["qos"]; ok {
+		fmt.Fprintf(reportOut, "QoS Level:           %d\n", qos)
+	}
+	if stats["warmup_sec"].(float64) > 0 {
+		fmt.Fprintf(reportOut, "Warmup Excluded:     %.0f sec (%d published, %d errors, avg %d ms)\n",
+			stats["warmup_sec"], stats["warmup_published"], stats["warmup_errors"], stats["warmup_avg_latency_ms"])
+	}
+	fmt.Fprintf(reportOut, "Total Published:     %d messages\n", stats["total_published"])
+	fmt.Fprintf(reportOut, "Total Errors:        %d\n", stats["total_errors"])
+	if injected := stats["injected_errors"].(int64); injected > 0 {
+		fmt.Fprintf(reportOut, "Injected Errors:     %d (-inject-error-rate)\n", injected)
+	}
+	fmt.Fprintf(reportOut, "Throughput:          %.2f msg/sec\n", stats["messages_per_sec"])
+	fmt.Fprintf(reportOut, "Bandwidth:           %.2f KB/sec (%d bytes total)\n", stats["bytes_per_sec"].(float64)/1024, stats["total_bytes"])
+	fmt.Fprintf(reportOut, "Avg Message Size:    %d bytes\n", stats["avg_message_bytes"])
+	fmt.Fprintf(reportOut, "Avg Latency:         %d ms\n", stats["avg_latency_ms"])
+	if stats["latency_sampled"].(bool) {
+		fmt.Fprintf(reportOut, "Percentiles:         approximate (%d-sample reservoir)\n", stats["latency_samples"])
+	}
+	fmt.Fprintf(reportOut, "P50 Latency:         %d ms\n", stats["p50_latency_ms"])
+	fmt.Fprintf(reportOut, "P95 Latency:         %d ms\n", stats["p95_latency_ms"])
+	fmt.Fprintf(reportOut, "P99 Latency:         %d ms\n", stats["p99_latency_ms"])
+	fmt.Fprintf(reportOut, "Min Latency:         %d ms\n", stats["min_latency_ms"])
+	fmt.Fprintf(reportOut, "Max Latency:         %d ms\n", stats["max_latency_ms"])
+	fmt.Fprintf(reportOut, "Std Dev Latency:     %.2f ms\n", stats["stddev_latency_ms"])
+	printHistogram(stats["latency_histogram"].([]LatencyBucket))
+	if stats["e2e_samples"].(int64) > 0 {
+		fmt.Fprintf(reportOut, "E2E P50 Latency:     %d ms\n", stats["e2e_p50_latency_ms"])
+		fmt.Fprintf(reportOut, "E2E P95 Latency:     %d ms\n", stats["e2e_p95_latency_ms"])
+		fmt.Fprintf(reportOut, "E2E P99 Latency:     %d ms\n", stats["e2e_p99_latency_ms"])
+		if clamped := stats["e2e_clamped"].(int64); clamped > 0 {
+			fmt.Fprintf(reportOut, "E2E Clamped:         %d negative latencies raised to 0\n", clamped)
+		}
+	}
+	if verified := stats["verified_messages"].(int64); verified > 0 {
+		fmt.Fprintf(reportOut, "Dropped Messages:    %d of %d (%.2f%%)\n", stats["dropped_messages"], verified, stats["drop_rate"])
+		fmt.Fprintf(reportOut, "Out of Order:        %d (%d duplicates)\n", stats["out_of_order_count"], stats["duplicate_count"])
+	}
+	if stats["uncompressed_bytes"].(int64) > 0 {
+		fmt.Fprintf(reportOut, "Compression:         %d -> %d bytes (ratio %.2f)\n",
+			stats["uncompressed_bytes"], stats["compressed_bytes"], stats["compression_ratio"])
+	}
+	if beats, errs := stats["heartbeats"].(int64), stats["heartbeat_errors"].(int64); beats+errs > 0 {
+		fmt.Fprintf(reportOut, "Heartbeats:          %d (%d errors)\n", beats, errs)
+	}
+	if slow := stats["slow_ticks"].(int64); slow > 0 {
+		fmt.Fprintf(reportOut, "Slow Ticks:          %d (publishing fell behind -interval; requested rate not achieved)\n", slow)
+	}
+	if churned := stats["churn_disconnects"].(int64); churned > 0 {
+		fmt.Fprintf(reportOut, "Churn Disconnects:   %d (%d reconnect errors)\n", churned, stats["churn_reconnect_errors"])
+	}
+	if buffered, dropped := stats["disconnect_buffered"].(int64), stats["disconnect_dropped"].(int64); buffered+dropped > 0 {
+		fmt.Fprintf(reportOut, "Held While Offline:  %d readings buffered, %d dropped\n", buffered, dropped)
+	}
+	if blocks := stats["inflight_blocks"].(int64); blocks > 0 {
+		fmt.Fprintf(reportOut, "In-Flight Blocks:    %d (%.2f sec waiting for a slot)\n", blocks, stats["inflight_wait_sec"])
+	}
+	if lost := stats["connections_lost"].(int64); lost > 0 {
+		fmt.Fprintf(reportOut, "Connections Lost:    %d (%d reconnected after %d attempts, %.2f sec total downtime)\n",
+			lost, stats["reconnect_count"], stats["reconnect_attempts"], stats["downtime_sec"])
+	}
+	if scale := stats["rate_scale"].(float64); scale > 0 {
+		fmt.Fprintf(reportOut, "Adaptive Rate:       %.0f%% at end, %.0f%% lowest (%d adjustments)\n",
+			scale*100, stats["min_rate_scale"].(float64)*100, stats["rate_adjustments"])
+	}
+	for _, b := range stats["brokers"].([]BrokerStats) {
+		fmt.Fprintf(reportOut, "Broker:              %s: %d published, %d errors, avg %d ms\n", b.Broker, b.Published, b.Errors, b.AvgLatencyMs)
+	}
+	if writeErrors := stats["metrics_write_errors"].(int64); writeErrors > 0 {
+		fmt.Fprintf(reportOut, "CSV Write Errors:    %d (metrics file may be incomplete)\n", writeErrors)
+	}
+	fmt.Fprintf(reportOut, "Elapsed Time:        %.2f sec\n", stats["elapsed_sec"])
+	fmt.Fprintln(reportOut, separator)
+}
+
+// PrintPerDeviceStats prints a per-device breakdown table to console
+func (m *MetricsTracker) PrintPerDeviceStats() {
+	devices := m.GetPerDeviceStats()
+	if jsonLogs {
+		for _, d := range devices {
+			slog.Info("device stats", "device_id", d.DeviceID, "published", d.Published,
+				"errors", d.Errors, "avg_latency_ms", d.AvgLatencyMs)
+		}
+		return
+	}
 	separator := strings.Repeat("=", 60)
-	
-	fmt.Println("\n" + separator)
-	fmt.Println("SIMULATOR METRICS")
-	fmt.Println(separator)
-	fmt.Printf("Total Published:     %d messages\n", stats["total_published"])
-	fmt.Printf("Total Errors:        %d\n", stats["total_errors"])
-	fmt.Printf("Throughput:          %.2f msg/sec\n", stats["messages_per_sec"])
-	fmt.Printf("Avg Latency:         %d ms\n", stats["avg_latency_ms"])
-	fmt.Printf("P50 Latency:         %d ms\n", stats["p50_latency_ms"])
-	fmt.Printf("P95 Latency:         %d ms\n", stats["p95_latency_ms"])
-	fmt.Printf("P99 Latency:         %d ms\n", stats["p99_latency_ms"])
-	fmt.Printf("Elapsed Time:        %.2f sec\n", stats["elapsed_sec"])
-	fmt.Println(separator)
-}
\ No newline at end of file
+
+	fmt.Fprintln(reportOut, "\n"+separator)
+	fmt.Fprintln(reportOut, "PER-DEVICE METRICS")
+	fmt.Fprintln(reportOut, separator)
+	fmt.Fprintf(reportOut, "%-20s %12s %10s %14s\n", "Device", "Published", "Errors", "Avg Latency")
+	for _, d := range devices {
+		fmt.Fprintf(reportOut, "%-20s %12d %10d %11d ms\n", d.DeviceID, d.Published, d.Errors, d.AvgLatencyMs)
+	}
+	fmt.Fprintln(reportOut, separator)
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestPercentile(t *testing.T) {
+	ascending := func(n int) []int64 {
+		s := make([]int64, n)
+		for i := range s {
+			s[i] = int64(i + 1)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name   string
+		sorted []int64
+		pct    int
+		want   int64
+	}{
+		{"one sample p50", ascending(1), 50, 1},
+		{"one sample p95", ascending(1), 95, 1},
+		{"one sample p99", ascending(1), 99, 1},
+		{"two samples p50", ascending(2), 50, 2},
+		{"two samples p95", ascending(2), 95, 2},
+		{"two samples p99", ascending(2), 99, 2},
+		{"twenty samples p50", ascending(20), 50, 11},
+		{"twenty samples p95", ascending(20), 95, 20},
+		{"twenty samples p99", ascending(20), 99, 20},
+		{"hundred samples p50", ascending(100), 50, 51},
+		{"hundred samples p95", ascending(100), 95, 96},
+		{"hundred samples p99", ascending(100), 99, 100},
+		{"hundred samples p100", ascending(100), 100, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.sorted, tt.pct); got != tt.want {
+				t.Errorf("percentile(%d samples, %d) = %d, want %d", len(tt.sorted), tt.pct, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// publishTotals are the per-publish counters, kept per shard and summed for stats
+type publishTotals struct {
+	publishCount    int64
+	publishErrors   int64
+	totalLatencyMs  int64
+	totalBytes      int64 // payload bytes of successful publishes
+	sumSqLatencyMs  float64
+	minLatencyMs    int64
+	maxLatencyMs    int64
+	warmupPublished int64
+	warmupErrors    int64
+	warmupLatencyMs int64
+	windowPublished int64
+	windowErrors    int64
+}
+
+// add sums o into t
+func (t *publishTotals) add(o publishTotals) {
+	if o.publishCount > 0 {
+		if t.publishCount == 0 || o.minLatencyMs < t.minLatencyMs {
+			t.minLatencyMs = o.minLatencyMs
+		}
+		t.maxLatencyMs = max(t.maxLatencyMs, o.maxLatencyMs)
+	}
+	t.publishCount += o.publishCount
+	t.publishErrors += o.publishErrors
+	t.totalLatencyMs += o.totalLatencyMs
+	t.totalBytes += o.totalBytes
+	t.sumSqLatencyMs += o.sumSqLatencyMs
+	t.warmupPublished += o.warmupPublished
+	t.warmupErrors += o.warmupErrors
+	t.warmupLatencyMs += o.warmupLatencyMs
+	t.windowPublished += o.windowPublished
+	t.windowErrors += o.windowErrors
+}
+
+// latencySpread returns min, max and population standard deviation of
+// successful publish latencies, tracked exactly from running sums
+func (t publishTotals) latencySpread() (minMs, maxMs int64, stddevMs float64) {
+	if t.publishCount == 0 {
+		return 0, 0, 0
+	}
+
+	n := float64(t.publishCount)
+	mean := float64(t.totalLatencyMs) / n
+	variance := t.sumSqLatencyMs/n - mean*mean
+	if variance < 0 {
+		variance = 0 // float rounding
+	}
+
+	return t.minLatencyMs, t.maxLatencyMs, math.Sqrt(variance)
+}
+
+// metricsShard holds the publish counters of the devices hashed to it.
+// RecordPublish locks only its device's shard, so thousands of devices do
+// not serialize on one tracker-wide lock; stats merge every shard.
+type metricsShard struct {
+	mu sync.Mutex
+	publishTotals
+	latencyCounts []int64 // exact successful publishes per bucket; one more than the bounds
+	recent        []int64 // ring of the latest latencies for -adaptive-rate; nil = off
+	recentNext    int
+	recentCount   int // latencies in recent since the last TakeRecentP95
+	devices       map[string]*deviceStat
+}
+
+// newMetricsShards creates n shards of per-publish counters
+func newMetricsShards(n int, opts MetricsOptions) []*metricsShard {
+	shards := make([]*metricsShard, max(n, 1))
+	for i := range shards {
+		shards[i] = &metricsShard{
+			latencyCounts: make([]int64, len(opts.LatencyBuckets)+1),
+			devices:       make(map[string]*deviceStat),
+		}
+	}
+	return shards
+}
+
+// shard returns the shard a device's publishes are recorded in (FNV-1a of the ID)
+func (m *MetricsTracker) shard(deviceID string) *metricsShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(deviceID); i++ {
+		h ^= uint32(deviceID[i])
+		h *= 16777619
+	}
+	return m.shards[h%uint32(len(m.shards))]
+}
+
+// record updates the shard's counters for one publish; warmup publishes
+// are counted separately from the steady state
+func (s *metricsShard) record(deviceID string, latencyMs int64, bytes int, success, warmup bool, bounds []int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if success && s.recent != nil {
+		s.recent[s.recentNext] = latencyMs
+		s.recentNext = (s.recentNext + 1) % len(s.recent)
+		s.recentCount = min(s.recentCount+1, len(s.recent))
+	}
+
+	if warmup {
+		if success {
+			s.warmupPublished++
+			s.warmupLatencyMs += latencyMs
+		} else {
+			s.warmupErrors++
+		}
+		return
+	}
+
+	if success {
+		s.publishCount++
+		s.windowPublished++
+		s.totalBytes += int64(bytes)
+		s.totalLatencyMs += latencyMs
+		s.sumSqLatencyMs += float64(latencyMs) * float64(latencyMs)
+		if s.publishCount == 1 || latencyMs < s.minLatencyMs {
+			s.minLatencyMs = latencyMs
+		}
+		if latencyMs > s.maxLatencyMs {
+			s.maxLatencyMs = latencyMs
+		}
+		s.latencyCounts[bucketIndex(bounds, latencyMs)]++
+	} else {
+		s.publishErrors++
+		s.windowErrors++
+	}
+
+	device, ok := s.devices[deviceID]
+	if !ok {
+		device = &deviceStat{}
+		s.devices[deviceID] = device
+	}
+	if success {
+		device.publishCount++
+		device.totalLatencyMs += latencyMs
+	} else {
+		device.publishErrors++
+	}
+}
+
+// shardTotals sums the counters and histogram buckets of every shard, and
+// counts the latency samples kept
+func (m *MetricsTracker) shardTotals() (totals publishTotals, latencyCounts []int64, samples int) {
+	latencyCounts = make([]int64, len(m.latencyBounds)+1)
+	for _, s := range m.shards {
+		s.mu.Lock()
+		totals.add(s.publishTotals)
+		for i, n := range s.latencyCounts {
+			latencyCounts[i] += n
+		}
+		s.mu.Unlock()
+	}
+	return totals, latencyCounts, m.latencies.len()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// BenchmarkRecordPublish compares recording publishes from many concurrent
+// devices on sharded counters against a single lock
+func BenchmarkRecordPublish(b *testing.B) {
+	for _, bench := range []struct {
+		name   string
+		shards int
+	}{
+		{"sharded", 16},
+		{"single-lock", 1},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			m := newTracker(time.Now(), MetricsOptions{LatencySampleSize: 100000, Shards: bench.shards})
+			var next atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				deviceID := fmt.Sprintf("watch-%04d", next.Add(1))
+				var latency int64
+				for pb.Next() {
+					latency = (latency + 7) % 250
+					m.record(deviceID, latency, 200, true, false)
+				}
+			})
+		})
+	}
+}
+
+// TestLatencySampleFillsWithFewDevices checks that the percentile sample is
+// not split by shard: two devices on many shards still fill it completely
+func TestLatencySampleFillsWithFewDevices(t *testing.T) {
+	const sampleSize = 1000
+	m := newTracker(time.Now(), MetricsOptions{LatencySampleSize: sampleSize, Shards: 64})
+	for i := 0; i < 5000; i++ {
+		m.record(fmt.Sprintf("watch-%04d", i%2), int64(i%100), 200, true, false)
+	}
+
+	if got := m.latencies.len(); got != sampleSize {
+		t.Errorf("latency samples = %d, want %d", got, sampleSize)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// DeviceStatus is the retained payload published to a device's status topic
+type DeviceStatus struct {
+	Status string `json:"status"`
+}
+
+// mqttSettings holds the broker connection settings shared by every device client
+type mqttSettings struct {
+	broker string
+	// clientIDPrefix starts every client ID; IDs are otherwise derived from
+	// the tenant and device, so they are stable across runs as persistent
+	// sessions require. Concurrent simulators need distinct prefixes.
+	clientIDPrefix string
+	// clientIDSuffix tells apart a device's clients on each fan-out broker,
+	// which may share sessions if they are nodes of one cluster
+	clientIDSuffix string
+	// cleanSession false asks the broker to keep each device's session across reconnects
+	cleanSession bool
+	keepAlive    time.Duration
+	pingTimeout  time.Duration
+	// connectTimeout bounds each connection attempt; failed attempts are
+	// retried up to connectRetries times with exponential backoff
+	connectTimeout time.Duration
+	connectRetries int
+	qos            byte
+	username       string
+	password       string
+	tlsConfig      *tls.Config
+	metrics        *MetricsTracker // records connection losses and reconnects
+}
+
+// clientOptions builds the MQTT options for one client
+func (s mqttSettings) clientOptions(clientID string) *mqtt.ClientOptions {
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(s.broker)
+	opts.SetClientID(clientID)
+	opts.SetKeepAlive(s.keepAlive)
+	opts.SetPingTimeout(s.pingTimeout)
+	opts.SetAutoReconnect(true)
+	opts.SetCleanSession(s.cleanSession)
+	if s.connectTimeout > 0 {
+		opts.SetConnectTimeout(s.connectTimeout)
+	}
+
+	// Surface the reconnects paho otherwise handles silently. lostAt is
+	// zero until the first connection loss, so the initial connect is
+	// not counted as a reconnect.
+	var lostAt atomic.Int64
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		lostAt.Store(time.Now().UnixNano())
+		log.Printf("🔌 [%s] Connection lost: %v", clientID, err)
+		if s.metrics != nil {
+			s.metrics.RecordConnectionLost()
+		}
+	})
+	opts.SetReconnectingHandler(func(mqtt.Client, *mqtt.ClientOptions) {
+		if s.metrics != nil {
+			s.metrics.RecordReconnectAttempt()
+		}
+	})
+	opts.SetOnConnectHandler(func(mqtt.Client) {
+		since := lostAt.Swap(0)
+		if since == 0 {
+			return
+		}
+		downtime := time.Since(time.Unix(0, since))
+		log.Printf("🔌 [%s] Reconnected after %v", clientID, downtime.Round(time.Millisecond))
+		if s.metrics != nil {
+			s.metrics.RecordReconnect(downtime)
+		}
+	})
+
+	if s.username != "" {
+		opts.SetUsername(s.username)
+		if s.password != "" {
+			opts.SetPassword(s.password)
+		}
+	}
+	if s.tlsConfig != nil {
+		opts.SetTLSConfig(s.tlsConfig)
+	}
+
+	return opts
+}
+
+// dial connects a new client with opts, retrying failed attempts with
+// exponential backoff. Cancelling ctx, e.g. on interrupt, abandons the
+// current attempt and any further retries.
+func (s mqttSettings) dial(ctx context.Context, opts *mqtt.ClientOptions) (mqtt.Client, error) {
+	backoff := connectBackoffInitial
+	for attempt := 0; ; attempt++ {
+		client := mqtt.NewClient(opts)
+		err := s.connectOnce(ctx, client)
+		if err == nil {
+			return client, nil
+		}
+		if ctx.Err() != nil || attempt >= s.connectRetries {
+			return nil, err
+		}
+
+		log.Printf("🔁 [%s] Connect attempt %d/%d failed: %v; retrying in %v",
+			opts.ClientID, attempt+1, s.connectRetries+1, err, backoff)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, connectBackoffMax)
+	}
+}
+
+// connectOnce makes a single connection attempt, bounded by the connect
+// timeout (paho applies it to the network dial as well)
+func (s mqttSettings) connectOnce(ctx context.Context, client mqtt.Client) error {
+	token := client.Connect()
+
+	var timeout <-chan time.Time
+	if s.connectTimeout > 0 {
+		timeout = time.After(s.connectTimeout)
+	}
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-timeout:
+		return fmt.Errorf("connect timed out after %v", s.connectTimeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// connectDevice opens a dedicated connection for a device with a retained
+// offline will on its status topic, then announces the device as online
+func (s mqttSettings) connectDevice(ctx context.Context, tenantID, deviceID string) (mqtt.Client, error) {
+	topic := statusTopic(tenantID, deviceID)
+
+	opts := s.clientOptions(s.clientID(tenantID + "-" + deviceID))
+	opts.SetBinaryWill(topic, statusPayload("offline"), s.qos, true)
+
+	client, err := s.dial(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if token := client.Publish(topic, s.qos, true, statusPayload("online")); token.Wait() && token.Error() != nil {
+		client.Disconnect(250)
+		return nil, fmt.Errorf("failed to publish online status: %w", token.Error())
+	}
+
+	return client, nil
+}
+
+// devicePublisher connects a device and returns a publisher that can drop
+// and re-establish that connection
+func (s mqttSettings) devicePublisher(ctx context.Context, tenantID, deviceID string, retained bool, timeout time.Duration) (*mqttPublisher, error) {
+	client, err := s.connectDevice(ctx, tenantID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	publisher := newMQTTPublisher(client, s.qos, retained, timeout)
+	publisher.statusTopic = statusTopic(tenantID, deviceID)
+	publisher.redial = func() (mqtt.Client, error) {
+		// Churn retries failed reconnects itself, so a single attempt each
+		once := s
+		once.connectRetries = 0
+		return once.connectDevice(context.Background(), tenantID, deviceID)
+	}
+	return publisher, nil
+}
+
+// replayPublisher opens the single connection that replay mode publishes
+// every recorded device's telemetry on
+func (s mqttSettings) replayPublisher(ctx context.Context, retained bool, timeout time.Duration) (*mqttPublisher, error) {
+	client, err := s.dial(ctx, s.clientOptions(s.clientID("replay")))
+	if err != nil {
+		return nil, err
+	}
+	return newMQTTPublisher(client, s.qos, retained, timeout), nil
+}
+
+const (
+	connectBackoffInitial = 500 * time.Millisecond // wait before the first connect retry
+	connectBackoffMax     = 10 * time.Second       // cap on the doubling backoff
+)
+
+// brokerSchemes are the -broker URL schemes paho can dial, and whether each
+// runs over TLS. ws/wss carry MQTT over WebSocket.
+var brokerSchemes = map[string]bool{
+	"tcp": false, "mqtt": false, "ws": false,
+	"ssl": true, "tls": true, "mqtts": true, "wss": true,
+}
+
+// brokerScheme returns the scheme of a broker URL and whether it uses TLS
+func brokerScheme(broker string) (scheme string, secure bool, err error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return "", false, fmt.Errorf("broker %q is not a valid URL: %w", broker, err)
+	}
+	secure, ok := brokerSchemes[u.Scheme]
+	if !ok {
+		return "", false, fmt.Errorf("broker scheme %q must be one of tcp, mqtt, ssl, tls, mqtts, ws or wss", u.Scheme)
+	}
+	return u.Scheme, secure, nil
+}
+
+// clientID returns the deterministic client ID for name, e.g. sim-acme-watch-0001
+func (s mqttSettings) clientID(name string) string {
+	return s.clientIDPrefix + "-" + name + s.clientIDSuffix
+}
+
+// forBrokers returns a copy of s for each broker. Clients on every broker
+// but the first get a -b<n> client ID suffix.
+func (s mqttSettings) forBrokers(brokers []string) []mqttSettings {
+	conns := make([]mqttSettings, len(brokers))
+	for i, broker := range brokers {
+		conns[i] = s
+		conns[i].broker = broker
+		if i > 0 {
+			conns[i].clientIDSuffix = fmt.Sprintf("-b%d", i)
+		}
+	}
+	return conns
+}
+
+// statusTopic returns the retained status topic for a device
+func statusTopic(tenantID, deviceID string) string {
+	return fmt.Sprintf("tenants/%s/devices/%s/status", tenantID, deviceID)
+}
+
+// metaTopic returns the retained metadata topic for a device (-publish-metadata)
+func metaTopic(tenantID, deviceID string) string {
+	return fmt.Sprintf("tenants/%s/devices/%s/meta", tenantID, deviceID)
+}
+
+// statusPayload encodes a DeviceStatus message
+func statusPayload(status string) []byte {
+	payload, _ := json.Marshal(DeviceStatus{Status: status})
+	return payload
+}
+
+// buildTLSConfig creates a TLS config from the optional CA and client
+// certificate files, which Config.Validate has checked exist and pair up
+func buildTLSConfig(caFile, certFile, keyFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+
+	// Load CA certificate
+	if caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("failed to parse CA certificate")
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	// Load client certificate
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
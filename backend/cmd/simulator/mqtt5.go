@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// mqttVersions are the -mqtt-version values; 3.1.1 uses the paho.mqtt.golang
+// client, 5 the paho.golang one
+var mqttVersions = map[string]bool{"3.1.1": true, "5": true}
+
+// defaultPingTimeout is the -ping-timeout default. The MQTT 5 client has no
+// ping timeout setting, so Validate rejects any other value with version 5.
+const defaultPingTimeout = 10 * time.Second
+
+// maxKeepAlive5 is the longest keepalive an MQTT 5 CONNECT can carry, in
+// whole seconds in a 16-bit field
+const maxKeepAlive5 = math.MaxUint16 * time.Second
+
+// mqtt5Publisher publishes a device's telemetry over MQTT 5, tagging every
+// message with tenant_id and device_id user properties and, with
+// -message-expiry, an expiry interval after which the broker drops it
+type mqtt5Publisher struct {
+	conn        *autopaho.ConnectionManager
+	cancel      context.CancelFunc // stops the connection manager's reconnects
+	connected   bool               // false once disconnected, until a reconnect
+	up          atomic.Bool        // the connection manager is connected, between its reconnects
+	qos         byte
+	retained    bool
+	timeout     time.Duration
+	statusTopic string
+	user        paho.UserProperties
+	expiry      *uint32 // seconds; nil = never expires
+	redial      func() (*autopaho.ConnectionManager, context.CancelFunc, error)
+}
+
+// Publish sends payload and waits for the broker, but never longer than the publish timeout
+func (p *mqtt5Publisher) Publish(ctx context.Context, topic string, payload []byte) error {
+	return p.publish(ctx, topic, payload, p.retained, p.expiry)
+}
+
+// PublishRetained sends payload as a retained message that never expires,
+// as device registrations must outlive the telemetry
+func (p *mqtt5Publisher) PublishRetained(ctx context.Context, topic string, payload []byte) error {
+	return p.publish(ctx, topic, payload, true, nil)
+}
+
+func (p *mqtt5Publisher) publish(ctx context.Context, topic string, payload []byte, retained bool, expiry *uint32) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	props := &paho.PublishProperties{User: p.user, MessageExpiry: expiry}
+	if encoding, compressed := topicEncoding(topic); !compressed {
+		props.ContentType = encoding.contentType
+	}
+
+	_, err := p.conn.Publish(ctx, &paho.Publish{
+		QoS:        p.qos,
+		Retain:     retained,
+		Topic:      topic,
+		Payload:    payload,
+		Properties: props,
+	})
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("publish timed out after %v", p.timeout)
+	}
+	return err
+}
+
+// announceOffline publishes the retained offline status, then disconnects
+func (p *mqtt5Publisher) announceOffline(ctx context.Context) error {
+	p.connected = false
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+		defer cancel()
+		p.conn.Disconnect(ctx)
+		p.cancel()
+	}()
+
+	if p.statusTopic == "" {
+		return nil
+	}
+	_, err := p.conn.Publish(ctx, &paho.Publish{
+		QoS:     p.qos,
+		Retain:  true,
+		Topic:   p.statusTopic,
+		Payload: statusPayload("offline"),
+	})
+	return err
+}
+
+// Disconnect announces the device offline on its status topic and drops the connection
+func (p *mqtt5Publisher) Disconnect() error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	return p.announceOffline(ctx)
+}
+
+// Connected reports whether the connection is up, for -on-disconnect
+func (p *mqtt5Publisher) Connected() bool {
+	return p.connected && p.up.Load()
+}
+
+// Reconnect replaces the dropped connection with a fresh one
+func (p *mqtt5Publisher) Reconnect() error {
+	conn, cancel, err := p.redial()
+	if err != nil {
+		return err
+	}
+	p.conn, p.cancel = conn, cancel
+	p.connected = true
+	return nil
+}
+
+// Online reports whether the device has not disconnected itself
+func (p *mqtt5Publisher) Online() bool {
+	return p.connected
+}
+
+// Shutdown publishes the retained offline status, waiting at most until
+// ctx is done, then disconnects. It does nothing if already disconnected.
+func (p *mqtt5Publisher) Shutdown(ctx context.Context) error {
+	if !p.connected {
+		return nil
+	}
+	if err := p.announceOffline(ctx); err != nil {
+		return fmt.Errorf("offline status not confirmed: %w", err)
+	}
+	return nil
+}
+
+// Close disconnects the device's MQTT connection
+func (p *mqtt5Publisher) Close() error {
+	if p.connected {
+		p.connected = false
+		ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+		defer cancel()
+		p.conn.Disconnect(ctx)
+		p.cancel()
+	}
+	return nil
+}
+
+// device5Publisher connects a device over MQTT 5 and returns a publisher
+// that can drop and re-establish that connection
+func (s mqttSettings) device5Publisher(ctx context.Context, tenantID, deviceID string, retained bool, timeout, expiry time.Duration) (*mqtt5Publisher, error) {
+	publisher := &mqtt5Publisher{
+		qos:         s.qos,
+		retained:    retained,
+		timeout:     timeout,
+		statusTopic: statusTopic(tenantID, deviceID),
+		user: paho.UserProperties{
+			{Key: "tenant_id", Value: tenantID},
+			{Key: "device_id", Value: deviceID},
+		},
+	}
+	if expiry > 0 {
+		seconds := uint32(expiry / time.Second)
+		publisher.expiry = &seconds
+	}
+	publisher.redial = func() (*autopaho.ConnectionManager, context.CancelFunc, error) {
+		// Churn retries failed reconnects itself, so a single attempt each
+		once := s
+		once.connectRetries = 0
+		return once.connectDevice5(context.Background(), tenantID, deviceID, &publisher.up)
+	}
+
+	conn, cancel, err := s.connectDevice5(ctx, tenantID, deviceID, &publisher.up)
+	if err != nil {
+		return nil, err
+	}
+	publisher.conn, publisher.cancel = conn, cancel
+	publisher.connected = true
+	return publisher, nil
+}
+
+// connectDevice5 opens a device's MQTT 5 connection with a retained offline
+// will on its status topic, then announces the device as online. Failed
+// attempts are retried up to connectRetries times with exponential backoff;
+// the connection manager keeps reconnecting after later connection losses,
+// tracking in up whether it is connected.
+func (s mqttSettings) connectDevice5(ctx context.Context, tenantID, deviceID string, up *atomic.Bool) (*autopaho.ConnectionManager, context.CancelFunc, error) {
+	broker, err := url.Parse(s.broker)
+	if err != nil {
+		return nil, nil, fmt.Errorf("broker %q is not a valid URL: %w", s.broker, err)
+	}
+	clientID := s.clientID(tenantID + "-" + deviceID)
+	topic := statusTopic(tenantID, deviceID)
+
+	// The connection outlives ctx, so a cancelled run can still announce
+	// the device offline; cancel stops it once disconnected
+	connCtx, cancel := context.WithCancel(context.Background())
+
+	var attempts atomic.Int64
+	var lostAt atomic.Int64
+	var lastErr atomic.Pointer[error]
+	cfg := autopaho.ClientConfig{
+		ServerUrls:                    []*url.URL{broker},
+		TlsCfg:                        s.tlsConfig,
+		KeepAlive:                     uint16(s.keepAlive / time.Second),
+		CleanStartOnInitialConnection: s.cleanSession,
+		ConnectTimeout:                s.connectTimeout,
+		ConnectUsername:               s.username,
+		ConnectPassword:               []byte(s.password),
+		WillMessage: &paho.WillMessage{
+			Retain:  true,
+			QoS:     s.qos,
+			Topic:   topic,
+			Payload: statusPayload("offline"),
+		},
+		ReconnectBackoff: func(attempt int) time.Duration {
+			if attempt == 0 {
+				return 0
+			}
+			return min(connectBackoffInitial<<min(attempt-1, 8), connectBackoffMax)
+		},
+		OnConnectError: func(err error) {
+			lastErr.Store(&err)
+			n := attempts.Add(1)
+			if lostAt.Load() != 0 {
+				// Reconnecting after a loss, which is retried indefinitely
+				if s.metrics != nil {
+					s.metrics.RecordReconnectAttempt()
+				}
+				return
+			}
+			if n > int64(s.connectRetries) {
+				cancel()
+				return
+			}
+			log.Printf("🔁 [%s] Connect attempt %d/%d failed: %v; retrying", clientID, n, s.connectRetries+1, err)
+		},
+		OnConnectionUp: func(*autopaho.ConnectionManager, *paho.Connack) {
+			up.Store(true)
+			since := lostAt.Swap(0)
+			if since == 0 {
+				return
+			}
+			downtime := time.Since(time.Unix(0, since))
+			log.Printf("🔌 [%s] Reconnected after %v", clientID, downtime.Round(time.Millisecond))
+			if s.metrics != nil {
+				s.metrics.RecordReconnectAttempt()
+				s.metrics.RecordReconnect(downtime)
+			}
+		},
+		OnConnectionDown: func() bool {
+			up.Store(false)
+			lostAt.Store(time.Now().UnixNano())
+			log.Printf("🔌 [%s] Connection lost", clientID)
+			if s.metrics != nil {
+				s.metrics.RecordConnectionLost()
+			}
+			return true
+		},
+		ClientConfig: paho.ClientConfig{ClientID: clientID},
+	}
+	if !s.cleanSession {
+		// MQTT 5 ends a session with its connection unless it asks for an expiry
+		cfg.SessionExpiryInterval = math.MaxUint32
+	}
+	if s.username == "" {
+		cfg.ConnectPassword = nil
+	}
+
+	conn, err := autopaho.NewConnection(connCtx, cfg)
+	if err != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("failed to create MQTT 5 client: %w", err)
+	}
+	if err := conn.AwaitConnection(ctx); err != nil {
+		cancel()
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		if last := lastErr.Load(); last != nil {
+			return nil, nil, *last
+		}
+		return nil, nil, err
+	}
+
+	if _, err := conn.Publish(ctx, &paho.Publish{QoS: s.qos, Retain: true, Topic: topic, Payload: statusPayload("online")}); err != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("failed to publish online status: %w", err)
+	}
+	return conn, cancel, nil
+}
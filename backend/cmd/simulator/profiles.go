@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profile describes a class of device or patient: how often it reports,
+// the ranges its baseline vitals are drawn from, and how anomaly-prone it
+// is. Built-in profiles can be extended or replaced under device_profiles
+// in the -config YAML. Zero fields keep the global setting.
+type Profile struct {
+	Interval     time.Duration `yaml:"interval"`      // 0 = the global -interval
+	Devices      []string      `yaml:"devices"`       // device IDs assigned to this profile
+	BaseHR       IntRange      `yaml:"base_hr"`       // resting heart rate, bpm
+	BaseTempC    FloatRange    `yaml:"base_temp_c"`   // body temperature, °C
+	BaseSpO2     IntRange      `yaml:"base_spo2"`     // oxygen saturation, %
+	AnomalyRate  *float64      `yaml:"anomaly_rate"`  // nil = the global -anomaly-rate
+	AnomalyTypes string        `yaml:"anomaly_types"` // empty = the global -anomaly-types
+	Schedule     *Schedule     `yaml:"schedule"`      // nil = always active
+}
+
+// IntRange is an inclusive range a baseline is drawn from
+type IntRange struct {
+	Min int `yaml:"min"`
+	Max int `yaml:"max"`
+}
+
+// FloatRange is a range a baseline is drawn from uniformly
+type FloatRange struct {
+	Min float64 `yaml:"min"`
+	Max float64 `yaml:"max"`
+}
+
+// draw picks a value in r, or in fallback if r is unset
+func (r IntRange) draw(fallback IntRange, rng *rand.Rand) int {
+	if r == (IntRange{}) {
+		r = fallback
+	}
+	return r.Min + rng.Intn(r.Max-r.Min+1)
+}
+
+// draw picks a value in r, or in fallback if r is unset
+func (r FloatRange) draw(fallback FloatRange, rng *rand.Rand) float64 {
+	if r == (FloatRange{}) {
+		r = fallback
+	}
+	return r.Min + rng.Float64()*(r.Max-r.Min)
+}
+
+// defaultProfile holds the baseline ranges of devices without a profile
+var defaultProfile = Profile{
+	BaseHR:    IntRange{Min: 70, Max: 99},
+	BaseTempC: FloatRange{Min: 36.5, Max: 37.5},
+	BaseSpO2:  IntRange{Min: 95, Max: 99},
+}
+
+// builtinProfiles are the patient profiles available without a config file
+var builtinProfiles = map[string]Profile{
+	"athlete": {
+		BaseHR:    IntRange{Min: 45, Max: 60},
+		BaseTempC: FloatRange{Min: 36.3, Max: 36.9},
+		BaseSpO2:  IntRange{Min: 97, Max: 99},
+	},
+	"elderly": {
+		BaseHR:      IntRange{Min: 65, Max: 85},
+		BaseTempC:   FloatRange{Min: 36.0, Max: 36.6},
+		BaseSpO2:    IntRange{Min: 93, Max: 96},
+		AnomalyRate: ptr(0.15),
+	},
+	"febrile": {
+		BaseHR:       IntRange{Min: 90, Max: 110},
+		BaseTempC:    FloatRange{Min: 38.0, Max: 39.0},
+		BaseSpO2:     IntRange{Min: 94, Max: 97},
+		AnomalyRate:  ptr(0.25),
+		AnomalyTypes: "fever,tachycardia",
+	},
+}
+
+// ptr returns a pointer to v, for optional profile fields
+func ptr[T any](v T) *T { return &v }
+
+// mergedProfiles returns the built-in profiles with those from the config
+// file added, replacing built-ins of the same name
+func mergedProfiles(custom map[string]Profile) map[string]Profile {
+	profiles := make(map[string]Profile, len(builtinProfiles)+len(custom))
+	for name, p := range builtinProfiles {
+		profiles[name] = p
+	}
+	for name, p := range custom {
+		profiles[name] = p
+	}
+	return profiles
+}
+
+// validate reports the first problem with a profile's settings
+func (p Profile) validate() error {
+	if p.Interval < 0 {
+		return fmt.Errorf("interval %v must be >= 0", p.Interval)
+	}
+	if p.BaseHR.Min > p.BaseHR.Max || p.BaseHR.Min < 0 {
+		return fmt.Errorf("base_hr range %d-%d is invalid", p.BaseHR.Min, p.BaseHR.Max)
+	}
+	if p.BaseTempC.Min > p.BaseTempC.Max || p.BaseTempC.Min < 0 {
+		return fmt.Errorf("base_temp_c range %.1f-%.1f is invalid", p.BaseTempC.Min, p.BaseTempC.Max)
+	}
+	if p.BaseSpO2.Min > p.BaseSpO2.Max || p.BaseSpO2.Min < 0 || p.BaseSpO2.Max > 100 {
+		return fmt.Errorf("base_spo2 range %d-%d is invalid", p.BaseSpO2.Min, p.BaseSpO2.Max)
+	}
+	if p.AnomalyRate != nil && (*p.AnomalyRate < 0 || *p.AnomalyRate > 1) {
+		return fmt.Errorf("anomaly rate %.4f must be between 0 and 1", *p.AnomalyRate)
+	}
+	if _, err := parseAnomalyTypes(p.AnomalyTypes); err != nil {
+		return err
+	}
+	if p.Schedule != nil {
+		return p.Schedule.compile()
+	}
+	return nil
+}
+
+// apply configures a device with the profile's overrides of the global settings
+func (p Profile) apply(cfg *DeviceConfig) {
+	cfg.Profile = p
+	if p.Interval > 0 {
+		cfg.Interval = p.Interval
+	}
+	if p.AnomalyRate != nil {
+		cfg.AnomalyRate = *p.AnomalyRate
+	}
+	if p.AnomalyTypes != "" {
+		cfg.AnomalyTypes, _ = parseAnomalyTypes(p.AnomalyTypes) // validated with the config
+	}
+	if p.Schedule != nil {
+		cfg.Schedule = p.Schedule
+	}
+}
+
+// profileAssignments maps each listed device ID to the name of its profile
+func profileAssignments(profiles map[string]Profile) (map[string]string, error) {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names) // deterministic error reporting
+
+	assigned := make(map[string]string)
+	for _, name := range names {
+		for _, id := range profiles[name].Devices {
+			if other, ok := assigned[id]; ok {
+				return nil, fmt.Errorf("device %s is assigned to both profile %s and %s", id, other, name)
+			}
+			assigned[id] = name
+		}
+	}
+	return assigned, nil
+}
+
+// ProfileWeight is one entry of a -profiles mix
+type ProfileWeight struct {
+	Name   string
+	Weight int
+}
+
+// ProfileMix is the weighted set of profiles assigned to devices that no
+// profile lists explicitly
+type ProfileMix []ProfileWeight
+
+// parseProfileMix parses a comma-separated list of name:weight pairs, e.g.
+// "athlete:20,elderly:50,febrile:30". A name without a weight counts as 1.
+func parseProfileMix(spec string, profiles map[string]Profile) (ProfileMix, error) {
+	var mix ProfileMix
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, weightStr, hasWeight := strings.Cut(entry, ":")
+		weight := 1
+		if hasWeight {
+			w, err := strconv.Atoi(weightStr)
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("profile weight %q for %s must be a positive integer", weightStr, name)
+			}
+			weight = w
+		}
+		if _, ok := profiles[name]; !ok {
+			return nil, fmt.Errorf("unknown profile %q", name)
+		}
+
+		mix = append(mix, ProfileWeight{Name: name, Weight: weight})
+	}
+
+	return mix, nil
+}
+
+// pick chooses a profile by weight
+func (m ProfileMix) pick(rng *rand.Rand) string {
+	total := 0
+	for _, p := range m {
+		total += p.Weight
+	}
+
+	n := rng.Intn(total)
+	for _, p := range m {
+		if n < p.Weight {
+			return p.Name
+		}
+		n -= p.Weight
+	}
+	return m[len(m)-1].Name
+}
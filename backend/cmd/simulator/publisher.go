@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Publisher delivers one telemetry payload to the backend. Implementations
+// must return promptly once ctx is cancelled.
+type Publisher interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+}
+
+// RetainedPublisher is implemented by publishers that can ask the broker to
+// keep a message for future subscribers, whatever -retained says
+type RetainedPublisher interface {
+	PublishRetained(ctx context.Context, topic string, payload []byte) error
+}
+
+// Shutdowner is implemented by publishers that announce a device offline
+// before closing its connection. Online is false once the device has
+// disconnected itself, e.g. on a depleted battery, leaving Shutdown nothing to do.
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+	Online() bool
+}
+
+// findPublisher returns the first publisher implementing T, looking through
+// wrappers such as verifyingPublisher
+func findPublisher[T any](publisher Publisher) (T, bool) {
+	for {
+		if t, ok := publisher.(T); ok {
+			return t, true
+		}
+		u, ok := publisher.(interface{ Unwrap() Publisher })
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		publisher = u.Unwrap()
+	}
+}
+
+// shutdownPublisher announces the device offline where supported, then
+// closes its connection; ctx bounds the announcement
+func shutdownPublisher(ctx context.Context, publisher Publisher) error {
+	if s, ok := findPublisher[Shutdowner](publisher); ok {
+		return s.Shutdown(ctx)
+	}
+	if c, ok := findPublisher[io.Closer](publisher); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// mqttPublisher adapts a device's MQTT connection to Publisher
+type mqttPublisher struct {
+	client      mqtt.Client
+	connected   bool // false once disconnected, until a reconnect
+	qos         byte
+	retained    bool
+	timeout     time.Duration
+	statusTopic string                      // set with redial to support churn
+	redial      func() (mqtt.Client, error) // opens a fresh device connection
+}
+
+// newMQTTPublisher wraps client; each publish wait is bounded by timeout
+func newMQTTPublisher(client mqtt.Client, qos byte, retained bool, timeout time.Duration) *mqttPublisher {
+	return &mqttPublisher{client: client, connected: true, qos: qos, retained: retained, timeout: timeout}
+}
+
+// Publish sends payload and waits for the broker, but never longer than the publish timeout
+func (p *mqttPublisher) Publish(ctx context.Context, topic string, payload []byte) error {
+	return p.publish(ctx, topic, payload, p.retained)
+}
+
+// PublishRetained sends payload as a retained message
+func (p *mqttPublisher) PublishRetained(ctx context.Context, topic string, payload []byte) error {
+	return p.publish(ctx, topic, payload, true)
+}
+
+func (p *mqttPublisher) publish(ctx context.Context, topic string, payload []byte, retained bool) error {
+	token := p.client.Publish(topic, p.qos, retained, payload)
+
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-time.After(p.timeout):
+		return fmt.Errorf("publish timed out after %v", p.timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Disconnect announces the device offline on its status topic and drops the connection
+func (p *mqttPublisher) Disconnect() error {
+	p.connected = false
+	defer p.client.Disconnect(250)
+
+	token := p.client.Publish(p.statusTopic, p.qos, true, statusPayload("offline"))
+	if !token.WaitTimeout(p.timeout) {
+		return fmt.Errorf("offline status timed out after %v", p.timeout)
+	}
+	return token.Error()
+}
+
+// Connected reports whether the connection is up; paho marks it down from
+// a connection loss until its automatic reconnect succeeds
+func (p *mqttPublisher) Connected() bool {
+	return p.connected && p.client.IsConnectionOpen()
+}
+
+// Reconnect replaces the dropped connection with a fresh one
+func (p *mqttPublisher) Reconnect() error {
+	if p.redial == nil {
+		return fmt.Errorf("publisher does not support reconnecting")
+	}
+
+	client, err := p.redial()
+	if err != nil {
+		return err
+	}
+	p.client = client
+	p.connected = true
+	return nil
+}
+
+// Online reports whether the device has not disconnected itself
+func (p *mqttPublisher) Online() bool {
+	return p.connected
+}
+
+// Shutdown publishes the retained offline status, waiting at most until
+// ctx is done, then disconnects. It does nothing if already disconnected.
+func (p *mqttPublisher) Shutdown(ctx context.Context) error {
+	if !p.connected {
+		return nil
+	}
+	p.connected = false
+	defer p.client.Disconnect(250)
+
+	if p.statusTopic == "" {
+		return nil
+	}
+	token := p.client.Publish(p.statusTopic, p.qos, true, statusPayload("offline"))
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return fmt.Errorf("offline status not confirmed: %w", ctx.Err())
+	}
+}
+
+// Close disconnects the device's MQTT connection
+func (p *mqttPublisher) Close() error {
+	if p.connected {
+		p.connected = false
+		p.client.Disconnect(250)
+	}
+	return nil
+}
+
+// httpPublisher POSTs each payload to endpoint/<topic>, e.g.
+// http://host/ingest/tenants/acme/devices/watch-0001/telemetry
+type httpPublisher struct {
+	endpoint string
+	client   *http.Client
+}
+
+// newHTTPPublisher creates a publisher shared by all devices
+func newHTTPPublisher(endpoint string, timeout time.Duration) *httpPublisher {
+	return &httpPublisher{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+// Publish POSTs payload as JSON and treats any non-2xx response as a failure
+func (p *httpPublisher) Publish(ctx context.Context, topic string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+"/"+topic, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	encoding, compressed := topicEncoding(topic)
+	req.Header.Set("Content-Type", encoding.contentType)
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// dryRunPublisher writes each message to out instead of sending it, one
+// "<topic> <payload>" line per publish like `mosquitto_sub -v`. Binary
+// payloads (protobuf, gzip) are base64-encoded to keep lines intact.
+type dryRunPublisher struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// newDryRunPublisher creates a publisher shared by all devices
+func newDryRunPublisher(out io.Writer) *dryRunPublisher {
+	return &dryRunPublisher{out: out}
+}
+
+// Publish writes the message line; it only fails if out does
+func (p *dryRunPublisher) Publish(_ context.Context, topic string, payload []byte) error {
+	body := string(payload)
+	if encoding, compressed := topicEncoding(topic); compressed || encoding.topicSuffix != "" || !utf8.Valid(payload) {
+		body = base64.StdEncoding.EncodeToString(payload)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := fmt.Fprintf(p.out, "%s %s\n", topic, body)
+	return err
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePublisher counts Publish calls and records what each one sent
+type fakePublisher struct {
+	mu       sync.Mutex
+	calls    int
+	topics   []string
+	payloads [][]byte
+	closed   bool
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, topic string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.calls++
+	p.topics = append(p.topics, topic)
+	p.payloads = append(p.payloads, slices.Clone(payload))
+	return nil
+}
+
+func (p *fakePublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	return nil
+}
+
+// TestPublisherRecordsTelemetry runs one device against the fake and checks
+// that every reading reaches the Publisher as a telemetry payload
+func TestPublisherRecordsTelemetry(t *testing.T) {
+	fake := &fakePublisher{}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	publishTelemetry(ctx, &wg, fake, newTracker(time.Now(), MetricsOptions{LatencySampleSize: 100}), "acme", "watch-0000", testDeviceConfig(t, 5*time.Millisecond), rand.New(rand.NewSource(1)))
+
+	if fake.calls == 0 || fake.calls != len(fake.payloads) {
+		t.Fatalf("calls = %d with %d payloads recorded, want at least one each", fake.calls, len(fake.payloads))
+	}
+	for i, payload := range fake.payloads {
+		var telemetry Telemetry
+		if err := json.Unmarshal(payload, &telemetry); err != nil {
+			t.Fatalf("payload %d is not telemetry JSON: %v", i, err)
+		}
+		if telemetry.TenantID != "acme" || telemetry.DeviceID != "watch-0000" {
+			t.Errorf("payload %d is for %s/%s, want acme/watch-0000", i, telemetry.TenantID, telemetry.DeviceID)
+		}
+	}
+}
+
+// TestPublisherThroughWrapper checks that a wrapped publisher receives every
+// payload unchanged and in order, and is still found and closed through
+// the wrapper
+func TestPublisherThroughWrapper(t *testing.T) {
+	fake := &fakePublisher{}
+	metrics := newTracker(time.Now(), MetricsOptions{LatencySampleSize: 100})
+	var publisher Publisher = &limitedPublisher{Publisher: fake, limiter: newInflightLimiter(1, metrics)}
+
+	sent := []string{`{"seq":0}`, `{"seq":1}`, `{"seq":2}`}
+	for _, payload := range sent {
+		if err := publisher.Publish(context.Background(), "tenants/acme/devices/watch-0000/telemetry", []byte(payload)); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+
+	if fake.calls != len(sent) {
+		t.Errorf("calls = %d, want %d", fake.calls, len(sent))
+	}
+	for i, payload := range fake.payloads {
+		if string(payload) != sent[i] {
+			t.Errorf("payload %d = %s, want %s", i, payload, sent[i])
+		}
+	}
+	if found, ok := findPublisher[*fakePublisher](publisher); !ok || found != fake {
+		t.Errorf("findPublisher did not unwrap to the fake")
+	}
+	if err := shutdownPublisher(context.Background(), publisher); err != nil || !fake.closed {
+		t.Errorf("shutdownPublisher: err %v, closed %v; want the fake closed", err, fake.closed)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	recordBufferSize    = 4096        // records queued before devices block
+	recordFlushInterval = time.Second // flush at least this often while records are pending
+)
+
+// telemetryRecorder writes every generated message as JSONL in the format
+// -replay reads, to a file (-record) or stdout (-stdout-ndjson). Like
+// csvSink, a single goroutine owns the output.
+type telemetryRecorder struct {
+	records chan RecordedTelemetry
+	done    chan struct{}
+	file    io.Closer // nil for stdout, which stays open
+	writer  *bufio.Writer
+	live    bool // flush as soon as no records are pending, for piping
+}
+
+// newTelemetryRecorder creates path and starts the writer goroutine
+func newTelemetryRecorder(path string) (*telemetryRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create record file: %w", err)
+	}
+	return newRecorder(file, file, false), nil
+}
+
+// newStdoutRecorder streams records to stdout as they are generated
+func newStdoutRecorder() *telemetryRecorder {
+	return newRecorder(os.Stdout, nil, true)
+}
+
+func newRecorder(out io.Writer, file io.Closer, live bool) *telemetryRecorder {
+	r := &telemetryRecorder{
+		records: make(chan RecordedTelemetry, recordBufferSize),
+		done:    make(chan struct{}),
+		file:    file,
+		writer:  bufio.NewWriter(out),
+		live:    live,
+	}
+	go r.run()
+	return r
+}
+
+// Record queues a message and the base topic it is published on
+func (r *telemetryRecorder) Record(topic string, telemetry Telemetry) {
+	r.records <- RecordedTelemetry{Topic: topic, Telemetry: telemetry}
+}
+
+// Close drains queued records, flushes, and closes the file
+func (r *telemetryRecorder) Close() {
+	close(r.records)
+	<-r.done
+}
+
+func (r *telemetryRecorder) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(recordFlushInterval)
+	defer ticker.Stop()
+
+	encoder := json.NewEncoder(r.writer)
+	for {
+		select {
+		case record, ok := <-r.records:
+			if !ok {
+				r.flush()
+				if r.file != nil {
+					r.file.Close()
+				}
+				return
+			}
+			if err := encoder.Encode(record); err != nil {
+				log.Printf("❌ Failed to record telemetry: %v", err)
+			}
+			if r.live && len(r.records) == 0 {
+				r.flush()
+			}
+		case <-ticker.C:
+			r.flush()
+		}
+	}
+}
+
+func (r *telemetryRecorder) flush() {
+	if err := r.writer.Flush(); err != nil {
+		log.Printf("❌ Failed to flush recorded telemetry: %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// liveParams are the settings a SIGHUP reload can change while the fleet
+// runs. They are swapped as a whole, so a device never sees half a reload.
+type liveParams struct {
+	AnomalyRate      float64
+	AnomalyTypes     []string
+	AnomalyTypeRates []AnomalyRate
+	FallProbability  float64
+	Scenario         *ScenarioEngine
+}
+
+// reloadableFields are the config keys covered by liveParams; any other key
+// that differs after a reload needs a restart and is reported as ignored
+var reloadableFields = map[string]bool{
+	"anomaly_rate":       true,
+	"anomaly_types":      true,
+	"anomaly_type_rates": true,
+	"fall_probability":   true,
+	"scenario":           true,
+}
+
+// refresh picks up the latest reloaded parameters. A device's profile keeps
+// its own anomaly rate and types over the global ones.
+func (cfg *DeviceConfig) refresh() {
+	if cfg.Live == nil {
+		return
+	}
+	live := cfg.Live.Load()
+	cfg.FallProbability = live.FallProbability
+	cfg.AnomalyTypeRates = live.AnomalyTypeRates
+	cfg.Scenario = live.Scenario
+	if cfg.Profile.AnomalyRate == nil {
+		cfg.AnomalyRate = live.AnomalyRate
+	}
+	if cfg.Profile.AnomalyTypes == "" {
+		cfg.AnomalyTypes = live.AnomalyTypes
+	}
+}
+
+// reloader rebuilds the configuration from the command line and -config
+// file on SIGHUP, the same way startup did, and applies what it can
+type reloader struct {
+	current    Config
+	configFile string
+	runStart   time.Time // scenario event times stay relative to the run
+	live       *atomic.Pointer[liveParams]
+}
+
+// run reloads on every signal from hup until ctx is cancelled
+func (r *reloader) run(ctx context.Context, hup <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reload()
+		}
+	}
+}
+
+// reload validates the new configuration and swaps in its runtime
+// parameters, logging each change. An invalid config changes nothing.
+func (r *reloader) reload() {
+	log.Println("🔄 SIGHUP received, reloading configuration...")
+	next, err := r.load()
+	if err != nil {
+		log.Printf("❌ Reload failed, keeping the current configuration:\n%v", err)
+		return
+	}
+
+	live := &liveParams{AnomalyRate: next.AnomalyRate, FallProbability: next.FallProbability}
+	live.AnomalyTypes, _ = parseAnomalyTypes(next.AnomalyTypes)         // validated above
+	live.AnomalyTypeRates, _ = parseAnomalyRates(next.AnomalyTypeRates) // validated above
+	if next.ScenarioFile != "" {
+		// Re-read even under the same path, the file may have been edited
+		if live.Scenario, err = LoadScenario(next.ScenarioFile, r.runStart); err != nil {
+			log.Printf("❌ Reload failed, keeping the current configuration: %v", err)
+			return
+		}
+	}
+
+	var applied, ignored []string
+	current := reflect.ValueOf(&r.current).Elem()
+	updated := reflect.ValueOf(next).Elem()
+	for i := 0; i < current.NumField(); i++ {
+		key := configKey(current.Type().Field(i))
+		before, after := current.Field(i), updated.Field(i)
+		if reflect.DeepEqual(before.Interface(), after.Interface()) {
+			continue
+		}
+		if !reloadableFields[key] {
+			ignored = append(ignored, key)
+			continue
+		}
+		applied = append(applied, fmt.Sprintf("%s %s -> %s", key, configValue(before), configValue(after)))
+		before.Set(after)
+	}
+
+	r.live.Store(live)
+	for _, change := range applied {
+		log.Printf("🔄 Reloaded %s", change)
+	}
+	if live.Scenario != nil {
+		log.Printf("🎬 Scenario reloaded from %s", next.ScenarioFile)
+	}
+	if len(ignored) > 0 {
+		log.Printf("⚠️  Changed but need a restart, ignored: %s", strings.Join(ignored, ", "))
+	}
+	if len(applied) == 0 && len(ignored) == 0 {
+		log.Println("🔄 Reload complete, no settings changed")
+	}
+}
+
+// load parses the original command line over a fresh config, then the
+// -config file, so explicit flags still win over the file
+func (r *reloader) load() (*Config, error) {
+	cfg := &Config{}
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	registerFlags(fs, cfg)
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		return nil, err
+	}
+	if r.configFile != "" {
+		if err := loadConfigFile(r.configFile, cfg, fs); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = r.current.Seed // derived from the clock at startup
+	}
+	return cfg, nil
+}
+
+// configValue formats a Config field for the reload log
+func configValue(v reflect.Value) string {
+	if v.Kind() == reflect.String {
+		return fmt.Sprintf("%q", v.String())
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// configKey names a Config field by its YAML key
+func configKey(field reflect.StructField) string {
+	if key, _, _ := strings.Cut(field.Tag.Get("yaml"), ","); key != "" {
+		return key
+	}
+	return field.Name
+}
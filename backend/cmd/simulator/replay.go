@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// replayMaxLine bounds a single JSONL record
+const replayMaxLine = 1 << 20
+
+// RecordedTelemetry is one JSONL line of a -replay file: a telemetry
+// message plus the topic it was published on. Topic may be omitted, in
+// which case it is rebuilt from the tenant and device IDs.
+type RecordedTelemetry struct {
+	Topic string `json:"topic,omitempty"`
+	Telemetry
+}
+
+// replayTelemetry republishes the records in path, spacing them by the
+// differences between their timestamps divided by speed. Payloads are
+// re-encoded with the configured encoding and compression.
+func replayTelemetry(ctx context.Context, wg *sync.WaitGroup, publisher Publisher, metrics *MetricsTracker, path string, speed float64, cfg DeviceConfig) error {
+	defer wg.Done()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open replay file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), replayMaxLine)
+
+	var firstTS, wallStart time.Time
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record RecordedTelemetry
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.Printf("⚠️  Replay line %d skipped: %v", line, err)
+			continue
+		}
+
+		// Wait until this record's offset from the first one, scaled by speed
+		if ts, err := parseTelemetryTime(record.Timestamp); err == nil {
+			if firstTS.IsZero() {
+				firstTS, wallStart = ts, time.Now()
+			}
+			due := wallStart.Add(time.Duration(float64(ts.Sub(firstTS)) / speed))
+			if wait := time.Until(due); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(wait):
+				}
+			}
+		}
+
+		topic := baseTopic(record.Topic)
+		if topic == "" {
+			topic = cfg.Topics.Topic(record.TenantID, record.DeviceID)
+		}
+		topic, payload, err := encodeTelemetry(record.Telemetry, topic, cfg, metrics)
+		if err != nil {
+			log.Printf("❌ [%s] Failed to encode telemetry: %v", record.DeviceID, err)
+			continue
+		}
+
+		if !metrics.ReservePublishes(1) {
+			return nil
+		}
+		startTime := time.Now()
+		publishErr := publisher.Publish(ctx, topic, payload)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		success := publishErr == nil
+		metrics.RecordPublish(record.TenantID, record.DeviceID, time.Since(startTime).Milliseconds(), len(payload), success, false)
+		if !success && cfg.ErrorLog.record(record.DeviceID, publishErr) {
+			log.Printf("❌ [%s] Publish error: %v", record.DeviceID, publishErr)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read replay file: %w", err)
+	}
+
+	return nil
+}
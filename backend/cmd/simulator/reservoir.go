@@ -0,0 +1,147 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+	"sync"
+)
+
+// latencyReservoir is the uniform sample of successful publish latencies
+// that percentiles are computed from. There is one per tracker rather than
+// one per shard: every publish has the same chance of being kept whichever
+// shard its device hashes to, and the sample fills to -latency-sample-size
+// even when a few devices leave most shards empty. Its lock covers only the
+// sampling step, not the shard counters.
+//
+// The sorted copy percentiles need is kept up to date incrementally: the
+// samples taken and replaced since the last snapshot are merged into it and
+// removed from it, so a stats tick costs O(n + k log k) for k changes, not a
+// full O(n log n) sort, also once the reservoir is full and replacing.
+type latencyReservoir struct {
+	mu      sync.Mutex
+	samples []int64
+	size    int
+	seen    int64 // latencies offered, kept or not
+	rand    *rand.Rand
+	sorted  []int64 // ascending samples as of the last snapshot; nil = not built
+	added   []int64 // samples taken since the snapshot
+	removed []int64 // samples replaced since the snapshot
+}
+
+// newLatencyReservoir returns a reservoir keeping at most size latencies
+func newLatencyReservoir(size int, seed int64) *latencyReservoir {
+	return &latencyReservoir{
+		samples: make([]int64, 0, min(size, 10000)),
+		size:    size,
+		rand:    rand.New(rand.NewSource(seed)),
+	}
+}
+
+// add offers a latency to the reservoir (Algorithm R)
+func (r *latencyReservoir) add(latencyMs int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seen++
+	if len(r.samples) < r.size {
+		r.samples = append(r.samples, latencyMs)
+		r.track(latencyMs, nil)
+		return
+	}
+	if j := r.rand.Int63n(r.seen); j < int64(r.size) {
+		old := r.samples[j]
+		r.samples[j] = latencyMs
+		r.track(latencyMs, &old)
+	}
+}
+
+// track notes a change for the next snapshot. Once the changes outnumber
+// the samples a rebuild is cheaper, so the sorted copy is dropped instead.
+// Caller must hold r.mu.
+func (r *latencyReservoir) track(added int64, removed *int64) {
+	if r.sorted == nil {
+		return
+	}
+	if len(r.added) >= len(r.samples) {
+		r.sorted, r.added, r.removed = nil, nil, nil
+		return
+	}
+	r.added = append(r.added, added)
+	if removed != nil {
+		r.removed = append(r.removed, *removed)
+	}
+}
+
+// len returns the number of latencies kept
+func (r *latencyReservoir) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.samples)
+}
+
+// sortedSnapshot returns the latencies in ascending order. Only the changes
+// since the previous call are sorted, then merged into and removed from
+// the cached result. The result must not be modified.
+func (r *latencyReservoir) sortedSnapshot() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sorted == nil {
+		r.sorted = slices.Clone(r.samples)
+		slices.Sort(r.sorted)
+		return r.sorted
+	}
+	if len(r.added) == 0 {
+		return r.sorted
+	}
+
+	// A replaced sample may itself have been taken since the snapshot, so
+	// removals apply to the merged result rather than the old copy
+	slices.Sort(r.added)
+	slices.Sort(r.removed)
+	r.sorted = subtractSorted(mergeSorted(r.sorted, r.added), r.removed)
+	r.added, r.removed = nil, nil
+	return r.sorted
+}
+
+// subtractSorted returns a without one occurrence of each element of
+// remove, both ascending; every element of remove must be in a
+func subtractSorted(a, remove []int64) []int64 {
+	if len(remove) == 0 {
+		return a
+	}
+
+	kept := make([]int64, 0, len(a)-len(remove))
+	for _, v := range a {
+		if len(remove) > 0 && remove[0] == v {
+			remove = remove[1:]
+			continue
+		}
+		kept = append(kept, v)
+	}
+	return kept
+}
+
+// mergeSorted merges two ascending slices into a new one; an empty side
+// returns the other as is
+func mergeSorted(a, b []int64) []int64 {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+
+	merged := make([]int64, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if a[0] <= b[0] {
+			merged = append(merged, a[0])
+			a = a[1:]
+		} else {
+			merged = append(merged, b[0])
+			b = b[1:]
+		}
+	}
+	merged = append(merged, a...)
+	return append(merged, b...)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// TestSortedSnapshotTracksReplacements checks the incrementally maintained
+// sorted copy against a full sort, before and after the reservoir fills
+func TestSortedSnapshotTracksReplacements(t *testing.T) {
+	r := newLatencyReservoir(500, 1)
+	rng := rand.New(rand.NewSource(2))
+	for round := 0; round < 50; round++ {
+		for i := 0; i < 1+rng.Intn(200); i++ {
+			r.add(rng.Int63n(300))
+		}
+
+		want := slices.Clone(r.samples)
+		slices.Sort(want)
+		if got := r.sortedSnapshot(); !slices.Equal(got, want) {
+			t.Fatalf("round %d: snapshot of %d samples differs from a full sort", round, len(want))
+		}
+	}
+}
+
+// BenchmarkSortedSnapshot measures a stats tick on a full 100k-sample
+// reservoir that keeps replacing samples, against sorting a copy each time
+func BenchmarkSortedSnapshot(b *testing.B) {
+	const samples, perTick = 100000, 1000
+
+	fill := func() *latencyReservoir {
+		r := newLatencyReservoir(samples, 1)
+		for i := 0; i < samples*2; i++ {
+			r.add(int64(i % 997))
+		}
+		return r
+	}
+
+	b.Run("incremental", func(b *testing.B) {
+		r := fill()
+		r.sortedSnapshot()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < perTick; j++ {
+				r.add(int64(j % 997))
+			}
+			r.sortedSnapshot()
+		}
+	})
+	b.Run("full-sort", func(b *testing.B) {
+		r := fill()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < perTick; j++ {
+				r.add(int64(j % 997))
+			}
+			sorted := slices.Clone(r.samples)
+			slices.Sort(sorted)
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Scenario is the JSON document loaded by -scenario. An event targets one
+// device_id, or part of the fleet with a devices selector (see DeviceSelector):
+//
+//	{
+//	  "events": [
+//	    {"name": "tachycardia", "device_id": "watch-0001", "at_sec": 30, "duration_sec": 60,
+//	     "metrics": {"hr_bpm": 165}},
+//	    {"name": "fever", "devices": "10%", "at_sec": 60, "duration_sec": 300,
+//	     "metrics": {"temp_c": 39.0}}
+//	  ]
+//	}
+type Scenario struct {
+	Events []ScenarioEvent `json:"events"`
+}
+
+// ScenarioEvent overrides metrics on one device, or the selected devices,
+// for a window of the run
+type ScenarioEvent struct {
+	Name        string `json:"name"`
+	DeviceID    string `json:"device_id"`
+	Devices     string `json:"devices"` // selector spec, instead of device_id
+	selector    *DeviceSelector
+	AtSec       float64         `json:"at_sec"`       // seconds after the run starts
+	DurationSec float64         `json:"duration_sec"` // how long the override lasts
+	Metrics     MetricOverrides `json:"metrics"`
+}
+
+// MetricOverrides lists the metric fields an event sets; omitted fields keep their generated value
+type MetricOverrides struct {
+	HeartRate     *int     `json:"hr_bpm,omitempty"`
+	TempC         *float64 `json:"temp_c,omitempty"`
+	SpO2          *int     `json:"spo2_pct,omitempty"`
+	SystolicMMHG  *int     `json:"bp_sys,omitempty"`
+	DiastolicMMHG *int     `json:"bp_dia,omitempty"`
+	RespRate      *int     `json:"resp_rate,omitempty"`
+	HRVms         *int     `json:"hrv_ms,omitempty"`
+	GlucoseMGDL   *int     `json:"glucose_mgdl,omitempty"`
+}
+
+// ScenarioEngine answers which scripted events are active for a device.
+// It is read-only after loading, so device goroutines can share it.
+type ScenarioEngine struct {
+	start    time.Time
+	byDevice map[string][]ScenarioEvent
+	selected []ScenarioEvent // events with a devices selector
+}
+
+// LoadScenario reads a scenario file; event times are relative to start
+func LoadScenario(path string, start time.Time) (*ScenarioEngine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario file: %w", err)
+	}
+
+	var scenario Scenario
+	if err := json.Unmarshal(data, &scenario); err != nil {
+		return nil, fmt.Errorf("failed to parse scenario file: %w", err)
+	}
+
+	var errs []error
+	engine := &ScenarioEngine{start: start, byDevice: make(map[string][]ScenarioEvent)}
+	for i, event := range scenario.Events {
+		event.DeviceID = strings.TrimSpace(event.DeviceID)
+		event.Devices = strings.TrimSpace(event.Devices)
+		switch {
+		case event.DeviceID == "" && event.Devices == "":
+			errs = append(errs, fmt.Errorf("event %d: device_id or devices is required", i))
+		case event.DeviceID != "" && event.Devices != "":
+			errs = append(errs, fmt.Errorf("event %d: set device_id or devices, not both", i))
+		case event.Devices != "":
+			if event.selector, err = parseDeviceSelector(event.Devices); err != nil {
+				errs = append(errs, fmt.Errorf("event %d: %w", i, err))
+			}
+		}
+		if event.AtSec < 0 {
+			errs = append(errs, fmt.Errorf("event %d: at_sec %.1f must be >= 0", i, event.AtSec))
+		}
+		if event.DurationSec <= 0 {
+			errs = append(errs, fmt.Errorf("event %d: duration_sec %.1f must be > 0", i, event.DurationSec))
+		}
+		if event.selector != nil {
+			engine.selected = append(engine.selected, event)
+		} else {
+			engine.byDevice[event.DeviceID] = append(engine.byDevice[event.DeviceID], event)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return engine, nil
+}
+
+// Apply overrides m with every event active at now for deviceID, the
+// device at index in the fleet, and reports whether any event applied
+func (e *ScenarioEngine) Apply(deviceID string, index int, now time.Time, m *Metrics) bool {
+	elapsed := now.Sub(e.start).Seconds()
+
+	applied := false
+	for _, event := range e.byDevice[deviceID] {
+		if elapsed < event.AtSec || elapsed >= event.AtSec+event.DurationSec {
+			continue
+		}
+		event.Metrics.apply(m)
+		applied = true
+	}
+	for _, event := range e.selected {
+		if elapsed < event.AtSec || elapsed >= event.AtSec+event.DurationSec || !event.selector.Matches(deviceID, index) {
+			continue
+		}
+		event.Metrics.apply(m)
+		applied = true
+	}
+
+	return applied
+}
+
+// apply copies every set override onto m
+func (o MetricOverrides) apply(m *Metrics) {
+	if o.HeartRate != nil {
+		m.HeartRate = *o.HeartRate
+	}
+	if o.TempC != nil {
+		m.TempC = *o.TempC
+	}
+	if o.SpO2 != nil {
+		m.SpO2 = *o.SpO2
+	}
+	if o.SystolicMMHG != nil {
+		m.SystolicMMHG = *o.SystolicMMHG
+	}
+	if o.DiastolicMMHG != nil {
+		m.DiastolicMMHG = *o.DiastolicMMHG
+	}
+	if o.RespRate != nil {
+		m.RespRate = *o.RespRate
+	}
+	if o.HRVms != nil {
+		m.HRVms = *o.HRVms
+	}
+	if o.GlucoseMGDL != nil {
+		m.GlucoseMGDL = *o.GlucoseMGDL
+	}
+}
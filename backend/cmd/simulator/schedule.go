@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// defaultHeartbeatInterval spaces heartbeats outside a schedule's windows
+const defaultHeartbeatInterval = time.Minute
+
+// Schedule limits a device to active time windows, e.g. business hours
+// for shift-based use. Outside every window the device publishes no
+// telemetry, only a periodic heartbeat on its status topic if Outside is
+// "heartbeat". Schedules are set per profile or per device in the -config
+// YAML.
+type Schedule struct {
+	Windows           []ScheduleWindow `yaml:"windows"`
+	Timezone          string           `yaml:"timezone"`           // IANA name, e.g. Europe/Berlin; empty = local
+	Outside           string           `yaml:"outside"`            // "silent" (default) or "heartbeat"
+	HeartbeatInterval time.Duration    `yaml:"heartbeat_interval"` // 0 = one minute
+
+	loc *time.Location
+}
+
+// ScheduleWindow is one daily active period. An End before Start runs
+// past midnight into the next day, e.g. a 22:00-06:00 night shift.
+type ScheduleWindow struct {
+	Days  string `yaml:"days"`  // e.g. "mon-fri" or "sat,sun"; empty = every day
+	Start string `yaml:"start"` // HH:MM
+	End   string `yaml:"end"`   // HH:MM
+
+	days       [7]bool // indexed by time.Weekday
+	start, end int     // minutes since midnight
+}
+
+// weekdays maps the day names a window's Days may use
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// compile validates the schedule and prepares it for Active
+func (s *Schedule) compile() error {
+	if len(s.Windows) == 0 {
+		return fmt.Errorf("schedule has no windows")
+	}
+	switch s.Outside {
+	case "", "silent", "heartbeat":
+	default:
+		return fmt.Errorf("schedule outside %q must be silent or heartbeat", s.Outside)
+	}
+	if s.HeartbeatInterval < 0 {
+		return fmt.Errorf("schedule heartbeat interval %v must be >= 0", s.HeartbeatInterval)
+	}
+
+	s.loc = time.Local
+	if s.Timezone != "" {
+		loc, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			return fmt.Errorf("schedule timezone: %w", err)
+		}
+		s.loc = loc
+	}
+
+	for i := range s.Windows {
+		if err := s.Windows[i].compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compile parses the window's days and times
+func (w *ScheduleWindow) compile() error {
+	var err error
+	if w.start, err = parseClock(w.Start); err != nil {
+		return err
+	}
+	if w.end, err = parseClock(w.End); err != nil {
+		return err
+	}
+	if w.start == w.end {
+		return fmt.Errorf("schedule window %s-%s is empty", w.Start, w.End)
+	}
+
+	if w.Days == "" {
+		w.days = [7]bool{true, true, true, true, true, true, true}
+		return nil
+	}
+	for _, part := range strings.Split(w.Days, ",") {
+		from, to, isRange := strings.Cut(strings.ToLower(strings.TrimSpace(part)), "-")
+		first, ok1 := weekdays[from]
+		last, ok2 := weekdays[to]
+		if !isRange {
+			last, ok2 = first, ok1
+		}
+		if !ok1 || !ok2 {
+			return fmt.Errorf("schedule days %q must be day names like mon-fri or sat,sun", w.Days)
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseClock parses HH:MM into minutes since midnight
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("schedule time %q must be HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Active reports whether t falls inside one of the schedule's windows
+func (s *Schedule) Active(t time.Time) bool {
+	t = t.In(s.loc)
+	minute := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+
+	for _, w := range s.Windows {
+		if w.start < w.end {
+			if w.days[today] && minute >= w.start && minute < w.end {
+				return true
+			}
+			continue
+		}
+		// Overnight: the evening part belongs to today, the morning part
+		// to the window that started yesterday
+		if (w.days[today] && minute >= w.start) || (w.days[yesterday] && minute < w.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendHeartbeat publishes an idle status for a device outside its schedule
+func sendHeartbeat(ctx context.Context, publisher Publisher, metrics *MetricsTracker, tenantID, deviceID string) {
+	err := publisher.Publish(ctx, statusTopic(tenantID, deviceID), statusPayload("idle"))
+	if ctx.Err() != nil {
+		return
+	}
+	metrics.RecordHeartbeat(err == nil)
+	if err != nil {
+		log.Printf("❌ [%s] Heartbeat error: %v", deviceID, err)
+	}
+}
+
+// heartbeatInterval returns how often an idle device sends a heartbeat,
+// or 0 if it stays silent
+func (s *Schedule) heartbeatInterval() time.Duration {
+	if s.Outside != "heartbeat" {
+		return 0
+	}
+	if s.HeartbeatInterval == 0 {
+		return defaultHeartbeatInterval
+	}
+	return s.HeartbeatInterval
+}
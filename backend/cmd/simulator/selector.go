@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DeviceSelector picks part of the fleet, e.g. the devices an anomaly or
+// scenario event affects. It is parsed from a comma-separated spec whose
+// terms are unioned: a device ID ("watch-0003"), an index range ("0-9"),
+// or a percentage of the fleet ("10%"). A percentage picks devices spread
+// evenly by index, so a fleet of n devices has exactly ceil(n*percent/100)
+// of them, the same ones from run to run.
+type DeviceSelector struct {
+	ids    map[string]bool
+	ranges [][2]int // inclusive device index ranges
+	share  int64    // percentage in hundredths of a percent, out of 10000
+}
+
+// parseDeviceSelector parses a selector spec; an empty spec returns nil,
+// which selects every device
+func parseDeviceSelector(spec string) (*DeviceSelector, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	s := &DeviceSelector{ids: make(map[string]bool)}
+	for _, term := range strings.Split(spec, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		if pct, ok := strings.CutSuffix(term, "%"); ok {
+			value, err := strconv.ParseFloat(pct, 64)
+			if err != nil || value < 0 || value > 100 {
+				return nil, fmt.Errorf("device percentage %q must be between 0%% and 100%%", term)
+			}
+			s.share = max(s.share, int64(math.Round(value*100)))
+			continue
+		}
+		if lo, hi, ok := strings.Cut(term, "-"); ok {
+			first, err1 := strconv.Atoi(lo)
+			last, err2 := strconv.Atoi(hi)
+			if err1 == nil && err2 == nil {
+				if first < 0 || first > last {
+					return nil, fmt.Errorf("device index range %q must be low-high with 0 <= low <= high", term)
+				}
+				s.ranges = append(s.ranges, [2]int{first, last})
+				continue
+			}
+			// Not numeric on both sides: a device ID such as watch-0003
+		}
+		s.ids[term] = true
+	}
+	return s, nil
+}
+
+// Matches reports whether the device at index in the fleet is selected.
+// A nil selector matches every device.
+func (s *DeviceSelector) Matches(deviceID string, index int) bool {
+	if s == nil || s.ids[deviceID] {
+		return true
+	}
+	for _, r := range s.ranges {
+		if index >= r[0] && index <= r[1] {
+			return true
+		}
+	}
+	// Device i is picked when it raises ceil(devices*share) by one, which
+	// keeps the count exact for every fleet size
+	i := int64(index)
+	return s.share > 0 && ceilDiv((i+1)*s.share, 10000) > ceilDiv(i*s.share, 10000)
+}
+
+// ceilDiv returns a/b rounded up, for a >= 0 and b > 0
+func ceilDiv(a, b int64) int64 {
+	return (a + b - 1) / b
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// SLO is the pass/fail target of a run (-slo-p95-ms, -slo-error-rate); a
+// run that misses either exits non-zero, so CI can gate on the exit code
+type SLO struct {
+	P95Ms     int64   // largest P95 publish latency; 0 = not checked
+	ErrorRate float64 // largest share of failed publishes, e.g. 0.01; < 0 = not checked
+}
+
+// enabled reports whether any SLO is set
+func (s SLO) enabled() bool {
+	return s.P95Ms > 0 || s.ErrorRate >= 0
+}
+
+// errorRate returns the share of publishes that failed
+func errorRate(stats map[string]interface{}) float64 {
+	published, _ := statFloat(stats["total_published"])
+	errors, _ := statFloat(stats["total_errors"])
+	if published+errors == 0 {
+		return 0
+	}
+	return errors / (published + errors)
+}
+
+// checkSLO compares the run's stats against each SLO, logging the outcome
+// of every one, and returns an error naming those that were violated
+func checkSLO(slo SLO, stats map[string]interface{}) error {
+	var violated int
+	report := func(name, detail string, ok bool) {
+		if ok {
+			logEvent(slog.LevelInfo, "✅ SLO met: "+detail, "slo met", "slo", name, "detail", detail)
+			return
+		}
+		violated++
+		logEvent(slog.LevelError, "❌ SLO violated: "+detail, "slo violated", "slo", name, "detail", detail)
+	}
+
+	if slo.P95Ms > 0 {
+		p95, _ := statFloat(stats["p95_latency_ms"])
+		report("p95_latency_ms", fmt.Sprintf("P95 latency %.0f ms (SLO %d ms)", p95, slo.P95Ms), p95 <= float64(slo.P95Ms))
+	}
+	if slo.ErrorRate >= 0 {
+		rate := errorRate(stats)
+		report("error_rate", fmt.Sprintf("error rate %.3f%% (SLO %.3f%%)", rate*100, slo.ErrorRate*100), rate <= slo.ErrorRate)
+	}
+
+	if violated > 0 {
+		return fmt.Errorf("%d SLOs violated", violated)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// savedDevice is one device's entry in the -state-file
+type savedDevice struct {
+	State DeviceState `json:"state"`
+	Seq   uint64      `json:"seq"` // next sequence number to publish
+}
+
+// deviceStateStore carries device state across restarts (-state-file):
+// loaded at startup, updated by each device as it stops, and written once
+// every device has stopped
+type deviceStateStore struct {
+	mu      sync.Mutex
+	devices map[string]savedDevice
+}
+
+// loadDeviceStates reads the state file at path; a missing file is a first
+// run and starts every device fresh
+func loadDeviceStates(path string) (*deviceStateStore, error) {
+	s := &deviceStateStore{devices: make(map[string]savedDevice)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.devices); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Restore returns the saved state of deviceID, if any
+func (s *deviceStateStore) Restore(deviceID string) (savedDevice, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved, ok := s.devices[deviceID]
+	return saved, ok
+}
+
+// Save records the state of deviceID as it stops
+func (s *deviceStateStore) Save(deviceID string, state DeviceState, seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices[deviceID] = savedDevice{State: state, Seq: seq}
+}
+
+// Seqs returns the next sequence number of every saved device
+func (s *deviceStateStore) Seqs() map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seqs := make(map[string]uint64, len(s.devices))
+	for id, saved := range s.devices {
+		seqs[id] = saved.Seq
+	}
+	return seqs
+}
+
+// Len returns the number of saved devices
+func (s *deviceStateStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.devices)
+}
+
+// Write saves every device's state to path. Devices from an earlier run
+// that did not run this time are kept. The file is replaced atomically, so
+// a crash mid-write leaves the previous state intact.
+func (s *deviceStateStore) Write(path string) error {
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s.devices, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal device state: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+	return nil
+}
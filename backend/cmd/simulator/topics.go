@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// defaultTopicTemplate is the telemetry topic scheme the HealthSense backend subscribes to
+const defaultTopicTemplate = "tenants/{{.TenantID}}/devices/{{.DeviceID}}/telemetry"
+
+// TopicTemplate renders a device's base telemetry topic from -topic-template.
+// Encoding and compression suffixes are appended after the rendered topic.
+type TopicTemplate struct {
+	tmpl *template.Template
+}
+
+// topicFields are the values available to a topic template
+type topicFields struct {
+	TenantID string
+	DeviceID string
+}
+
+// parseTopicTemplate parses text and test-renders it once, so a bad
+// template fails at startup rather than on every publish
+func parseTopicTemplate(text string) (*TopicTemplate, error) {
+	tmpl, err := template.New("topic").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid topic template: %w", err)
+	}
+	t := &TopicTemplate{tmpl: tmpl}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, topicFields{TenantID: "tenant", DeviceID: "device"}); err != nil {
+		return nil, fmt.Errorf("invalid topic template: %w", err)
+	}
+	topic := b.String()
+	if topic == "" {
+		return nil, fmt.Errorf("topic template %q renders an empty topic", text)
+	}
+	if strings.ContainsAny(topic, "+#") {
+		return nil, fmt.Errorf("topic template %q must not contain MQTT wildcards", text)
+	}
+
+	return t, nil
+}
+
+// Topic returns the base telemetry topic for a device
+func (t *TopicTemplate) Topic(tenantID, deviceID string) string {
+	var b strings.Builder
+	t.tmpl.Execute(&b, topicFields{TenantID: tenantID, DeviceID: deviceID}) // validated by parseTopicTemplate
+	return b.String()
+}
+
+// Subscription returns a topic filter matching every device's telemetry in
+// any encoding. IDs that don't fill a whole topic level can't be matched
+// with "+", so such templates fall back to subscribing to everything.
+func (t *TopicTemplate) Subscription() string {
+	filter := t.Topic("+", "+") + "/#"
+	for _, level := range strings.Split(filter, "/") {
+		if strings.Contains(level, "+") && level != "+" {
+			return "#"
+		}
+	}
+	return filter
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	verifyGrace      = 5 * time.Second // how long to wait for in-flight deliveries at shutdown
+	verifyDropsShown = 10              // devices listed in the drop summary
+)
+
+// Verifier subscribes to the simulator's own telemetry and confirms that
+// every published message is delivered. Published payloads are registered
+// by content hash before they are sent, so each delivery can be matched to
+// exactly one publish.
+type Verifier struct {
+	mu          sync.Mutex
+	client      mqtt.Client
+	pending     map[uint64]int // payload hash -> publishes not yet delivered
+	devices     map[string]*deviceDelivery
+	expected    int64
+	delivered   int64
+	unexpected  int64 // deliveries with no matching publish (duplicates or foreign)
+	outOfOrder  int64 // deliveries filling an earlier sequence gap
+	duplicates  int64 // deliveries of a sequence number already seen
+	seqResets   int64 // devices that restarted their sequence at 0
+	parseErrors int64
+	metrics     *MetricsTracker // receives end-to-end latencies
+	clockSkew   time.Duration   // added to every end-to-end latency
+	warnedSkew  bool
+
+	// -verify-processing-delay: how long each delivery is held before it is
+	// counted and acknowledged, and the lag and backlog that builds up
+	processingDelay time.Duration
+	lastLag         time.Duration // publish to processed, for the latest delivery
+	maxLag          time.Duration
+	peakBacklog     int64 // most publishes not yet processed when a delivery was
+}
+
+// deviceDelivery counts one device's verified publishes and deliveries and
+// tracks its sequence numbers: nextSeq is one past the highest delivered,
+// and gaps holds skipped sequence numbers that may still arrive late.
+type deviceDelivery struct {
+	expected  int64
+	delivered int64
+	nextSeq   uint64
+	gaps      map[uint64]struct{}
+}
+
+// DeviceDrops is a device's undelivered publishes, as returned by DroppedByDevice
+type DeviceDrops struct {
+	DeviceID string
+	Expected int64
+	Dropped  int64
+}
+
+// VerifyStats summarizes delivery as seen by the subscriber
+type VerifyStats struct {
+	Expected     int64
+	Delivered    int64
+	Missing      int64
+	Unexpected   int64
+	OutOfOrder   int64
+	Duplicates   int64
+	SeqResets    int64
+	ParseErrors  int64
+	DeliveryRate float64       // percentage of expected messages delivered
+	LastLag      time.Duration // -verify-processing-delay only
+	MaxLag       time.Duration
+	PeakBacklog  int64
+}
+
+// newVerifier connects a dedicated subscribing client on topic, a filter
+// matching every device's telemetry, and starts matching deliveries.
+// clockSkew corrects for the publisher's clock running ahead of (negative)
+// or behind (positive) the subscriber's. A processingDelay simulates a slow
+// consumer: deliveries are handled one at a time and acknowledged only once
+// handled, so the broker has to buffer behind it.
+func newVerifier(ctx context.Context, conn mqttSettings, metrics *MetricsTracker, clockSkew, processingDelay time.Duration, topic string) (*Verifier, error) {
+	v := &Verifier{
+		pending:         make(map[uint64]int),
+		devices:         make(map[string]*deviceDelivery),
+		metrics:         metrics,
+		clockSkew:       clockSkew,
+		processingDelay: processingDelay,
+	}
+
+	opts := conn.clientOptions(conn.clientID("verifier"))
+	opts.SetCleanSession(true) // a resumed session would replay messages from earlier runs
+	client, err := conn.dial(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect verifier: %w", err)
+	}
+
+	if token := client.Subscribe(topic, conn.qos, v.onMessage); token.Wait() && token.Error() != nil {
+		client.Disconnect(250)
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", topic, token.Error())
+	}
+
+	v.client = client
+	return v, nil
+}
+
+// Expect registers a payload that is about to be published on topic and
+// reports whether it will be verified. Only telemetry is; other publishes,
+// such as schedule heartbeats, are not delivered to the subscriber.
+func (v *Verifier) Expect(topic string, payload []byte) bool {
+	telemetry, err := decodeTelemetry(topic, payload)
+	if err != nil || telemetry.Timestamp == "" {
+		return false
+	}
+	key := payloadHash(payload)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.pending[key]++
+	v.expected++
+	v.device(telemetry.DeviceID).expected++
+	return true
+}
+
+// Forget withdraws a payload whose publish failed
+func (v *Verifier) Forget(topic string, payload []byte) {
+	key := payloadHash(payload)
+	telemetry, _ := decodeTelemetry(topic, payload)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.pending[key] > 0 {
+		v.decrement(key)
+		v.expected--
+		v.device(telemetry.DeviceID).expected--
+	}
+}
+
+// device returns the delivery counts of deviceID. Caller must hold v.mu.
+func (v *Verifier) device(deviceID string) *deviceDelivery {
+	d, ok := v.devices[deviceID]
+	if !ok {
+		d = &deviceDelivery{}
+		v.devices[deviceID] = d
+	}
+	return d
+}
+
+// onMessage matches a delivery against the pending publishes
+func (v *Verifier) onMessage(_ mqtt.Client, msg mqtt.Message) {
+	received := time.Now()
+	key := payloadHash(msg.Payload())
+
+	telemetry, parseErr := decodeTelemetry(msg.Topic(), msg.Payload())
+	sentAt, tsErr := parseTelemetryTime(telemetry.Timestamp)
+
+	// The message is acknowledged when this callback returns
+	if v.processingDelay > 0 {
+		time.Sleep(v.processingDelay)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if parseErr != nil || tsErr != nil {
+		v.parseErrors++
+		return
+	}
+	if v.processingDelay > 0 {
+		v.lastLag = time.Since(sentAt) + v.clockSkew
+		v.maxLag = max(v.maxLag, v.lastLag)
+		v.peakBacklog = max(v.peakBacklog, v.expected-v.delivered)
+	}
+	v.checkSeq(v.device(telemetry.DeviceID), telemetry.Seq)
+	if v.pending[key] == 0 {
+		v.unexpected++
+		return
+	}
+
+	v.decrement(key)
+	v.delivered++
+	v.device(telemetry.DeviceID).delivered++
+
+	// Skewed clocks can put the receive time before the embedded timestamp
+	latency := received.Sub(sentAt) + v.clockSkew
+	clamped := latency < 0
+	if clamped {
+		if !v.warnedSkew {
+			log.Printf("⚠️  Negative end-to-end latency %v clamped to 0; adjust -clock-skew", latency)
+			v.warnedSkew = true
+		}
+		latency = 0
+	}
+	v.metrics.RecordE2ELatency(latency.Milliseconds(), clamped)
+}
+
+// checkSeq classifies a delivery of seq against the device's earlier
+// deliveries. A 0 after later numbers is a device that restarted its
+// counter, e.g. a fresh clean session, rather than a late first message.
+// Caller must hold v.mu.
+func (v *Verifier) checkSeq(d *deviceDelivery, seq uint64) {
+	_, gap := d.gaps[seq]
+	switch {
+	case seq >= d.nextSeq:
+		for missing := d.nextSeq; missing < seq; missing++ {
+			if d.gaps == nil {
+				d.gaps = make(map[uint64]struct{})
+			}
+			d.gaps[missing] = struct{}{}
+		}
+		d.nextSeq = seq + 1
+	case gap:
+		delete(d.gaps, seq)
+		v.outOfOrder++
+	case seq == 0 && d.nextSeq > 1:
+		d.nextSeq, d.gaps = 1, nil
+		v.seqResets++
+	default:
+		v.duplicates++
+	}
+}
+
+// Resume continues the sequence of devices restored from -state-file, so
+// numbers published before the restart are not counted as gaps
+func (v *Verifier) Resume(seqs map[string]uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for deviceID, seq := range seqs {
+		v.device(deviceID).nextSeq = seq
+	}
+}
+
+// decrement removes one pending publish for key. Caller must hold v.mu.
+func (v *Verifier) decrement(key uint64) {
+	if v.pending[key]--; v.pending[key] == 0 {
+		delete(v.pending, key)
+	}
+}
+
+// Wait blocks until every expected message has been delivered or timeout elapses
+func (v *Verifier) Wait(timeout time.Duration) {
+	// A slow consumer needs time to work through its backlog
+	v.mu.Lock()
+	timeout += time.Duration(v.expected-v.delivered) * v.processingDelay
+	v.mu.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		v.mu.Lock()
+		missing := v.expected - v.delivered
+		v.mu.Unlock()
+
+		if missing <= 0 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Close disconnects the subscribing client
+func (v *Verifier) Close() {
+	v.client.Disconnect(250)
+}
+
+// Stats returns the current delivery summary
+func (v *Verifier) Stats() VerifyStats {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	stats := VerifyStats{
+		Expected:    v.expected,
+		Delivered:   v.delivered,
+		Missing:     v.expected - v.delivered,
+		Unexpected:  v.unexpected,
+		OutOfOrder:  v.outOfOrder,
+		Duplicates:  v.duplicates,
+		SeqResets:   v.seqResets,
+		ParseErrors: v.parseErrors,
+		LastLag:     v.lastLag,
+		MaxLag:      v.maxLag,
+		PeakBacklog: v.peakBacklog,
+	}
+	if v.expected > 0 {
+		stats.DeliveryRate = float64(v.delivered) / float64(v.expected) * 100
+	}
+
+	return stats
+}
+
+// DroppedByDevice returns the devices with publishes that were never
+// delivered, most drops first. At QoS 0 the broker may discard messages
+// without the publisher noticing, so these are the only record of them.
+func (v *Verifier) DroppedByDevice() []DeviceDrops {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var drops []DeviceDrops
+	for id, d := range v.devices {
+		if missing := d.expected - d.delivered; missing > 0 {
+			drops = append(drops, DeviceDrops{DeviceID: id, Expected: d.expected, Dropped: missing})
+		}
+	}
+	sort.Slice(drops, func(i, j int) bool {
+		if drops[i].Dropped != drops[j].Dropped {
+			return drops[i].Dropped > drops[j].Dropped
+		}
+		return drops[i].DeviceID < drops[j].DeviceID
+	})
+	return drops
+}
+
+// PrintStats prints the delivery summary
+func (v *Verifier) PrintStats() {
+	stats := v.Stats()
+	separator := strings.Repeat("=", 60)
+
+	fmt.Fprintln(reportOut, "\n"+separator)
+	fmt.Fprintln(reportOut, "DELIVERY VERIFICATION")
+	fmt.Fprintln(reportOut, separator)
+	fmt.Fprintf(reportOut, "Expected:            %d\n", stats.Expected)
+	fmt.Fprintf(reportOut, "Delivered:           %d\n", stats.Delivered)
+	fmt.Fprintf(reportOut, "Missing:             %d\n", stats.Missing)
+	fmt.Fprintf(reportOut, "Unexpected:          %d\n", stats.Unexpected)
+	fmt.Fprintf(reportOut, "Out of Order:        %d\n", stats.OutOfOrder)
+	fmt.Fprintf(reportOut, "Duplicates:          %d\n", stats.Duplicates)
+	if stats.SeqResets > 0 {
+		fmt.Fprintf(reportOut, "Sequence Resets:     %d\n", stats.SeqResets)
+	}
+	fmt.Fprintf(reportOut, "Parse Errors:        %d\n", stats.ParseErrors)
+	fmt.Fprintf(reportOut, "Delivery Rate:       %.2f%%\n", stats.DeliveryRate)
+	if v.processingDelay > 0 {
+		fmt.Fprintf(reportOut, "Processing Delay:    %v per message\n", v.processingDelay)
+		fmt.Fprintf(reportOut, "Consumer Lag:        %v at the end (max %v)\n", stats.LastLag.Round(time.Millisecond), stats.MaxLag.Round(time.Millisecond))
+		fmt.Fprintf(reportOut, "Peak Backlog:        %d messages\n", stats.PeakBacklog)
+	}
+	fmt.Fprintln(reportOut, separator)
+
+	if stats.Missing > 0 {
+		log.Printf("⚠️  %d published messages were not delivered", stats.Missing)
+		drops := v.DroppedByDevice()
+		for i, d := range drops {
+			if i == verifyDropsShown {
+				log.Printf("   ... and %d more devices", len(drops)-i)
+				break
+			}
+			log.Printf("   %s: %d of %d dropped", d.DeviceID, d.Dropped, d.Expected)
+		}
+	}
+}
+
+// verifyingPublisher registers every payload with a Verifier before publishing it
+type verifyingPublisher struct {
+	Publisher
+	verifier *Verifier
+}
+
+// Publish registers the payload, then publishes it; failed publishes are withdrawn
+func (p *verifyingPublisher) Publish(ctx context.Context, topic string, payload []byte) error {
+	verified := p.verifier.Expect(topic, payload)
+	err := p.Publisher.Publish(ctx, topic, payload)
+	if err != nil && verified {
+		p.verifier.Forget(topic, payload)
+	}
+	return err
+}
+
+// Unwrap returns the wrapped publisher
+func (p *verifyingPublisher) Unwrap() Publisher {
+	return p.Publisher
+}
+
+// payloadHash identifies a payload for delivery matching
+func payloadHash(payload []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(payload)
+	return h.Sum64()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Build identity, set with -ldflags, e.g.
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without them, commit and buildTime fall back to the VCS stamp go build embeds.
+var (
+	version   = "dev"
+	commit    = ""
+	buildTime = ""
+)
+
+// buildInfo identifies the simulator binary
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+	Modified  bool   `json:"modified,omitempty"` // built from a tree with uncommitted changes
+}
+
+// readBuildInfo returns the ldflags build identity, completed from the
+// module build info
+func readBuildInfo() buildInfo {
+	build := buildInfo{Version: version, Commit: commit, BuildTime: buildTime}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return build
+	}
+	build.GoVersion = info.GoVersion
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if build.Commit == "" {
+				build.Commit = s.Value
+			}
+		case "vcs.time":
+			if build.BuildTime == "" {
+				build.BuildTime = s.Value
+			}
+		case "vcs.modified":
+			build.Modified = s.Value == "true"
+		}
+	}
+	return build
+}
+
+// String formats the build for -version and the startup log
+func (b buildInfo) String() string {
+	s := b.Version
+	if b.Commit != "" {
+		s += " (commit " + b.Commit
+		if b.Modified {
+			s += ", modified"
+		}
+		s += ")"
+	}
+	if b.BuildTime != "" {
+		s += " built " + b.BuildTime
+	}
+	return fmt.Sprintf("%s, %s", s, b.GoVersion)
+}
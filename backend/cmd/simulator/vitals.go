@@ -0,0 +1,215 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	circadianPeakHour    = 16.0 // body temperature and heart rate peak in the late afternoon
+	circadianHRSwing     = 8    // bpm above/below baseline at peak/trough
+	circadianTempSwing   = 0.3  // degrees C above/below baseline at peak/trough
+	circadianNightSteps  = 0.1  // share of the step maximum still possible at the trough
+	circadianShiftMaxMin = 90   // per-device body clock offset, in minutes either way
+)
+
+// DeviceState holds the evolving simulation state of a single device
+type DeviceState struct {
+	BaseHR   int
+	BaseTemp float64
+	BaseSpO2 int
+	BaseSys  int
+	BaseDia  int
+	BaseResp int
+	BaseHRV  int
+	Steps    int
+	StepsDay string
+	Active   bool
+	Battery  float64
+	Lat      float64
+	Lon      float64
+	// CircadianShift offsets the device's body clock from wall-clock time (-circadian)
+	CircadianShift time.Duration
+	GlucoseBase    float64   // fasting glucose in mg/dL (-glucose)
+	GlucosePeaks   []float64 // post-meal peak per meal time
+	// AnomalyType is the sustained anomaly active until AnomalyUntil (-anomaly-duration)
+	AnomalyType  string
+	AnomalyUntil time.Time
+}
+
+// VitalsModel generates heart rate, temperature and SpO2, together since
+// the correlated model derives heart rate from the other two
+type VitalsModel func(state *DeviceState, r Reading, m *Metrics)
+
+func (f VitalsModel) Generate(state *DeviceState, r Reading, m *Metrics) { f(state, r, m) }
+
+// vitalsModels are the generators selectable with -vitals-model
+var vitalsModels = map[string]VitalsModel{
+	"independent": generateIndependentVitals,
+	"correlated":  generateVitals,
+}
+
+// newDeviceState draws baselines from the device's profile, applying any
+// configured per-device override
+func newDeviceState(profile Profile, baseline DeviceOverride, rng *rand.Rand) *DeviceState {
+	state := &DeviceState{
+		BaseHR:   profile.BaseHR.draw(defaultProfile.BaseHR, rng),
+		BaseTemp: profile.BaseTempC.draw(defaultProfile.BaseTempC, rng),
+		BaseSpO2: profile.BaseSpO2.draw(defaultProfile.BaseSpO2, rng),
+		StepsDay: time.Now().UTC().Format("2006-01-02"),
+		Active:   rng.Float64() < 0.3,
+		BaseSys:  110 + rng.Intn(21),
+		BaseDia:  70 + rng.Intn(16),
+		BaseResp: 12 + rng.Intn(9),
+		BaseHRV:  20 + rng.Intn(81),
+		Battery:  100,
+	}
+
+	if baseline.BaseHR > 0 {
+		state.BaseHR = baseline.BaseHR
+	}
+	if baseline.BaseTempC > 0 {
+		state.BaseTemp = baseline.BaseTempC
+	}
+	if baseline.BaseSpO2 > 0 {
+		state.BaseSpO2 = baseline.BaseSpO2
+	}
+
+	return state
+}
+
+// circadianPhase returns the day/night cycle position at now for the
+// device's body clock: +1 at the afternoon peak, -1 at the pre-dawn trough
+func (s *DeviceState) circadianPhase(now time.Time) float64 {
+	local := now.Add(s.CircadianShift).Local()
+	hour := float64(local.Hour()) + float64(local.Minute())/60 + float64(local.Second())/3600
+	return math.Cos(2 * math.Pi * (hour - circadianPeakHour) / 24)
+}
+
+// circadianState returns a copy of the state with baseline heart rate and
+// temperature shifted for the time of day, for passing to a VitalsModel
+func (s *DeviceState) circadianState(phase float64) *DeviceState {
+	shifted := *s
+	shifted.BaseHR += int(math.Round(circadianHRSwing * phase))
+	shifted.BaseTemp += circadianTempSwing * phase
+	return &shifted
+}
+
+// circadianStepsMax scales the per-interval step maximum down at night
+func circadianStepsMax(maxPerInterval int, phase float64) int {
+	day := (1 + phase) / 2
+	return int(float64(maxPerInterval) * (circadianNightSteps + (1-circadianNightSteps)*day))
+}
+
+// updateSteps advances the activity model and returns the steps added this
+// interval. Devices drift between active and resting periods, steps only
+// accumulate while active, and the count resets at UTC midnight.
+func (s *DeviceState) updateSteps(now time.Time, maxPerInterval int, rng *rand.Rand) int {
+	if today := now.UTC().Format("2006-01-02"); today != s.StepsDay {
+		s.Steps = 0
+		s.StepsDay = today
+	}
+
+	if rng.Float64() < 0.1 {
+		s.Active = !s.Active
+	}
+	if !s.Active {
+		return 0
+	}
+
+	delta := rng.Intn(maxPerInterval + 1)
+	s.Steps += delta
+	return delta
+}
+
+// generateIndependentVitals draws each vital independently around its baseline
+// (the original simulator behaviour). Anomalies are tachycardia plus fever.
+func generateIndependentVitals(state *DeviceState, r Reading, m *Metrics) {
+	rng := r.Rng
+	m.HeartRate = state.BaseHR + rng.Intn(21) - 10
+	m.TempC = state.BaseTemp + (rng.Float64()*0.4 - 0.2)
+	m.SpO2 = clampSpO2(state.BaseSpO2 + rng.Intn(3) - 1)
+
+	if r.Anomaly {
+		m.HeartRate = 150 + rng.Intn(30)
+		m.TempC = 38.0 + rng.Float64()
+	}
+}
+
+// generateVitals models physiological correlations: activity raises heart
+// rate and slightly raises temperature, fever raises heart rate by ~10 bpm
+// per degree, and low SpO2 drives compensatory tachycardia
+func generateVitals(state *DeviceState, r Reading, m *Metrics) {
+	rng, activity := r.Rng, r.Activity
+	temp := state.BaseTemp + 0.3*activity + (rng.Float64()*0.2 - 0.1)
+	spo2 := clampSpO2(state.BaseSpO2 + rng.Intn(3) - 1)
+
+	// Anomaly: fever with a tachycardic response
+	surge := 0.0
+	if r.Anomaly {
+		temp = 38.0 + rng.Float64()
+		surge = 40 + float64(rng.Intn(20))
+	}
+
+	hr := float64(state.BaseHR) + 50*activity + surge + float64(rng.Intn(11)-5)
+	if temp > 37.0 {
+		hr += 10 * (temp - 37.0)
+	}
+	if spo2 < 95 {
+		hr += 2 * float64(95-spo2)
+	}
+
+	m.HeartRate = int(hr)
+	m.TempC = temp
+	m.SpO2 = spo2
+}
+
+// clampSpO2 keeps a saturation reading within 0-100 percent; a baseline of
+// 99-100 plus noise would otherwise exceed it, whatever -bounds says
+func clampSpO2(spo2 int) int {
+	return min(max(spo2, 0), 100)
+}
+
+// bloodPressure draws systolic/diastolic readings around the device baseline;
+// exertion raises systolic markedly and diastolic only slightly
+func bloodPressure(state *DeviceState, activity float64, rng *rand.Rand) (sys, dia int) {
+	sys = state.BaseSys + int(20*activity) + rng.Intn(7) - 3
+	dia = state.BaseDia + int(5*activity) + rng.Intn(5) - 2
+	return sys, dia
+}
+
+// hypertensiveCrisis raises blood pressure on roughly half of all anomalies
+func hypertensiveCrisis(m *Metrics, rng *rand.Rand) {
+	if rng.Float32() < 0.5 {
+		m.SystolicMMHG += 30 + rng.Intn(21)
+		m.DiastolicMMHG += 15 + rng.Intn(11)
+	}
+}
+
+// respiration draws respiratory rate and heart-rate variability; exertion
+// quickens breathing and suppresses HRV
+func respiration(state *DeviceState, activity float64, rng *rand.Rand) (respRate, hrvMs int) {
+	respRate = state.BaseResp + int(10*activity) + rng.Intn(3) - 1
+	hrvMs = int(float64(state.BaseHRV)*(1-0.5*activity)) + rng.Intn(11) - 5
+	if hrvMs < 5 {
+		hrvMs = 5
+	}
+	return respRate, hrvMs
+}
+
+// fallResponse flags a fall and adds the startle heart-rate spike that follows it
+func fallResponse(m *Metrics, rng *rand.Rand) {
+	m.FallDetected = true
+	m.HeartRate += 30 + rng.Intn(21)
+}
+
+// distress mimics physiological stress during an anomaly: faster breathing
+// and markedly reduced HRV
+func distress(m *Metrics, rng *rand.Rand) {
+	m.RespRate += 6 + rng.Intn(7)
+	m.HRVms = m.HRVms * (30 + rng.Intn(21)) / 100
+	if m.HRVms < 5 {
+		m.HRVms = 5
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// TestSpO2StaysInRange generates SpO2 from both vitals models, with the
+// built-in anomaly and every anomaly type, at the extreme baselines
+func TestSpO2StaysInRange(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for name, model := range vitalsModels {
+		for _, base := range []int{0, 1, 99, 100} {
+			state := newDeviceState(Profile{}, DeviceOverride{}, rng)
+			state.BaseSpO2 = base
+			for i := 0; i < 5000; i++ {
+				r := Reading{Activity: rng.Float64(), Anomaly: i%3 == 0, Time: time.Now(), Interval: time.Second, Rng: rng}
+				var m Metrics
+				model(state, r, &m)
+				if m.SpO2 < 0 || m.SpO2 > 100 {
+					t.Fatalf("%s model, baseline %d: SpO2 %d out of [0,100]", name, base, m.SpO2)
+				}
+				for anomaly, apply := range anomalyTypes {
+					mutated := m
+					apply(&mutated, rng)
+					if mutated.SpO2 < 0 || mutated.SpO2 > 100 {
+						t.Fatalf("%s model, baseline %d, %s: SpO2 %d out of [0,100]", name, base, anomaly, mutated.SpO2)
+					}
+				}
+			}
+		}
+	}
+}